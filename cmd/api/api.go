@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	_ "github.com/critma/goblog/docs"
+	"github.com/critma/goblog/internal/store"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	httpSwagger "github.com/swaggo/http-swagger"
@@ -16,40 +19,113 @@ func (app *application) mount() http.Handler {
 
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+	r.Use(app.LanguageMiddleware)
+	r.Use(app.TracingMiddleware)
+	r.Use(app.AccessLogMiddleware)
+	r.Use(app.MetricsMiddleware)
+	r.Use(app.RecoverMiddleware)
+
+	r.Use(middleware.Timeout(app.config().server.handlerTimeout))
+	r.Use(app.SecurityHeadersMiddleware)
+	r.Use(app.CORSMiddleware)
+	r.Use(app.BodyLimitMiddleware)
+
+	// set before routes, subrouters inherit them
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		app.notFoundResponse(w, r, errors.New("no route matches the path"))
+	})
+	r.MethodNotAllowed(app.methodNotAllowedResponse)
 
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Get("/.well-known/jwks.json", app.getJWKSHandler)
+	r.Get("/healthz", app.healthzHandler)
+	r.Get("/readyz", app.readyzHandler)
 
 	r.Route("/api/v1", func(r chi.Router) {
-		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config.addr)
-		r.Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
+		r.Use(app.RateLimitMiddleware("global"))
+
+		docsURL := fmt.Sprintf("%s/swagger/doc.json", app.config().server.addr)
+		r.With(app.HTMLPageMiddleware).Get("/swagger/*", httpSwagger.Handler(httpSwagger.URL(docsURL)))
 
 		r.Route("/auth", func(r chi.Router) {
+			r.Use(app.RateLimitMiddleware("auth"))
 			r.Post("/reg", app.registerUserHandler)
 			r.Post("/log", app.loginUserHandler)
+
+			r.Route("/2fa", func(r chi.Router) {
+				r.Post("/verify", app.verifyTwoFactorHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Post("/enroll", app.enrollTwoFactorHandler)
+					r.Post("/confirm", app.confirmTwoFactorHandler)
+					r.Post("/disable", app.disableTwoFactorHandler)
+					r.Post("/recovery-codes", app.regenerateRecoveryCodesHandler)
+				})
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.AuthTokenMiddleware)
+				r.Post("/logout", app.logoutHandler)
+				r.Get("/sessions", app.getSessionsHandler)
+				r.Delete("/sessions", app.deleteOtherSessionsHandler)
+				r.Delete("/sessions/{id}", app.deleteSessionHandler)
+			})
+
+			r.Route("/passkeys", func(r chi.Router) {
+				r.Post("/login/begin", app.beginPasskeyLoginHandler)
+				r.Post("/login/finish", app.finishPasskeyLoginHandler)
+				r.Group(func(r chi.Router) {
+					r.Use(app.AuthTokenMiddleware)
+					r.Get("/", app.getPasskeysHandler)
+					r.Delete("/{id}", app.deletePasskeyHandler)
+					r.Post("/register/begin", app.beginPasskeyRegistrationHandler)
+					r.Post("/register/finish", app.finishPasskeyRegistrationHandler)
+				})
+			})
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(app.AuthTokenMiddleware)
+			r.Use(app.TwoFactorEnrolledMiddleware)
+			r.Use(app.RequireRoleMiddleware(store.RoleAdmin))
+			r.Put("/users/{id}/2fa", app.setTwoFactorRequiredHandler)
+			r.Get("/cache", app.getCacheStatsHandler)
+			r.Post("/config/reload", app.reloadConfigHandler)
 		})
 
 		r.Route("/users", func(r chi.Router) {
 			r.Route("/{id}", func(r chi.Router) {
-				r.Get("/", app.getUserByIDHandler)
+				r.With(app.OptionalAuthMiddleware, app.ConditionalGetMiddleware(app.config().httpCache.users)).
+					Get("/", app.getUserByIDHandler)
 			})
 		})
 
 		r.Route("/articles", func(r chi.Router) {
-			r.Get("/", app.getLatestArticlesHandler)
+			r.With(app.ConditionalGetMiddleware(app.config().httpCache.feed)).
+				Get("/", app.getLatestArticlesHandler)
 			r.Group(func(r chi.Router) { // with middleware
 				r.Use(app.AuthTokenMiddleware)
+				r.Use(app.TwoFactorEnrolledMiddleware)
 				r.Post("/", app.createArticleHandler)
-				r.Route("/{id}", func(r chi.Router) {
-					r.Use(app.articleContextMiddleware)
-					r.Get("/", app.getArticleByID)
-
-					r.Route("/comments", func(r chi.Router) {
-						r.Get("/", app.getArticleCommentsHandler)
-						r.Post("/", app.createArticleCommentHandler)
-					})
+			})
+			r.With(app.OptionalAuthMiddleware, app.ConditionalGetMiddleware(app.config().httpCache.articleList)).
+				Get("/list", app.listArticlesHandler)
+			// readable anonymously, the viewer fields need a user
+			r.Route("/{id}", func(r chi.Router) {
+				r.Use(app.OptionalAuthMiddleware)
+				r.Use(app.articleContextMiddleware)
+				r.With(app.ConditionalGetMiddleware(app.config().httpCache.article)).
+					Get("/", app.getArticleByID)
+				r.With(app.ConditionalGetMiddleware(app.config().httpCache.comments)).
+					Get("/comments", app.getArticleCommentsHandler)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireAuthMiddleware)
+					r.Use(app.TwoFactorEnrolledMiddleware)
+					r.With(app.RateLimitMiddleware("comments")).
+						Post("/comments", app.createArticleCommentHandler)
 					r.Post("/like", app.createLikeOnArticle)
+					r.Post("/bookmark", app.createBookmarkHandler)
+					r.Delete("/bookmark", app.deleteBookmarkHandler)
 
 					r.Group(func(r chi.Router) {
 						r.Use(app.CheckArticleOwnershipMiddleware)
@@ -57,30 +133,114 @@ func (app *application) mount() http.Handler {
 						r.Patch("/", app.updateArticleHandler)
 					})
 				})
-				r.Get("/author/{id}", app.getArticlesByUserID)
 			})
+			r.With(app.OptionalAuthMiddleware, app.ConditionalGetMiddleware(app.config().httpCache.authorArticles)).
+				Get("/author/{id}", app.getArticlesByUserID)
 		})
 	})
 
 	return r
 }
 
-func (app *application) run(mux http.Handler) error {
+// run serves until ctx is done, then stops accepting connections and drains in order:
+// in-flight requests, background tasks started by them, workers.
+// Whatever is left after the shutdown timeout is dropped and errForcedShutdown returned.
+func (app *application) run(ctx context.Context, mux http.Handler) error {
 	srv := &http.Server{
-		Addr:         app.config.addr,
-		Handler:      mux,
-		WriteTimeout: time.Second * 60,
-		ReadTimeout:  time.Second * 15,
-		IdleTimeout:  time.Minute,
+		Addr:              app.config().server.addr,
+		Handler:           mux,
+		WriteTimeout:      app.config().server.writeTimeout,
+		ReadTimeout:       app.config().server.readTimeout,
+		ReadHeaderTimeout: app.config().server.readHeaderTimeout,
+		IdleTimeout:       app.config().server.idleTimeout,
 	}
 
-	app.logger.Infow("server start on ", "addr", app.config.addr)
-	err := srv.ListenAndServe()
-	if err != nil {
+	serveErr := make(chan error, 2)
+	go func() {
+		app.logger.Infow("server start on ", "addr", app.config().server.addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	metricsSrv := app.metricsServer()
+	if metricsSrv != nil {
+		go func() {
+			app.logger.Infow("metrics server start", "addr", metricsSrv.Addr)
+			serveErr <- metricsSrv.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serveErr:
 		return err
+	case <-ctx.Done():
 	}
 
-	app.logger.Infow("server stop", "addr", app.config.addr)
+	app.logger.Infow("server shutting down", "addr", app.config().server.addr, "timeout", app.config().server.shutdownTimeout)
+	app.shuttingDown.Store(true)
+	time.Sleep(app.config().server.shutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config().server.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// long requests and streams are cut off
+		srv.Close()
+		errs = append(errs, fmt.Errorf("http: %v", err))
+	}
+	// tasks and workers get what is left of the budget, workers are told to stop in any case
+	if err := waitContext(shutdownCtx, &app.tasks); err != nil {
+		errs = append(errs, fmt.Errorf("background tasks: %v", err))
+	}
+	if err := app.stopWorkers(shutdownCtx); err != nil {
+		errs = append(errs, fmt.Errorf("workers: %v", err))
+	}
+	// metrics are scraped until the end of the drain
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			metricsSrv.Close()
+			errs = append(errs, fmt.Errorf("metrics: %v", err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", errForcedShutdown, errors.Join(errs...))
+	}
+
+	app.logger.Infow("server stop", "addr", app.config().server.addr)
 
 	return nil
 }
+
+// metricsServer serves /metrics on the internal address, nil when it is disabled
+func (app *application) metricsServer() *http.Server {
+	cfg := app.config().server
+	if cfg.metricsAddr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", app.metrics.registry.Handler())
+	return &http.Server{
+		Addr:              cfg.metricsAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
+}
+
+// background runs fn outside of the request, panics are logged instead of crashing the server.
+// Shutdown waits for running tasks.
+func (app *application) background(fn func()) {
+	app.tasks.Add(1)
+	go func() {
+		defer app.tasks.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Errorw("background task panic", "error", fmt.Sprint(err))
+			}
+		}()
+
+		fn()
+	}()
+}
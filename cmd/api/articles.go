@@ -2,7 +2,6 @@ package main
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"strconv"
 
@@ -17,6 +16,9 @@ const articleCtx articleKey = "article"
 type CreateArticlePayload struct {
 	Title   string `json:"title" validate:"required,max=100"`
 	Content string `json:"content" validate:"required,max=100"`
+	// published when empty
+	Status string   `json:"status" validate:"omitempty,oneof=draft published"`
+	Tags   []string `json:"tags" validate:"max=10,dive,max=50"`
 }
 
 // @Summary		Get latest articles
@@ -24,95 +26,148 @@ type CreateArticlePayload struct {
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Success		200	{object}	[]store.LatestArticle
-// @Failure		400	{object}	error
-// @Failure		500	{object}	error
+// @Success		200	{object}	ListResponse{items=[]store.LatestArticle}
+// @Failure		400	{object}	Problem
+// @Failure		500	{object}	Problem
 // @Router			/articles [get]
 func (app *application) getLatestArticlesHandler(w http.ResponseWriter, r *http.Request) {
 	latests, err := app.store.Articles.GetLastTen(r.Context())
 	if err != nil {
 		app.internalServerError(w, r, err)
+		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, latests); err != nil {
-		app.internalServerError(w, r, err)
-	}
+	n := len(latests)
+	app.listResponse(w, r, latests, fixedPage(n), false, &n)
 }
 
 // @Summary		Get article by id
-// @Description	Get article by id
+// @Description	Get article by id, anonymously or with liked_by_me, bookmarked_by_me and can_edit of the viewer
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Param			id	path		int	true	"Article ID"
-// @Success		200	{object}	store.Article
-// @Failure		400	{object}	error
-// @Failure		404	{object}	error
-// @Failure		500	{object}	error
-// @Security		ApiKeyAuth
+// @Param			id		path		int		true	"Article ID"
+// @Param			fields	query		string	false	"comma separated fields to return"
+// @Param			include	query		string	false	"comma separated of author, comments, tags"
+// @Success		200		{object}	store.Article
+// @Header			200		{string}	ETag	"version of the article, for If-Match"
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		404		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Router			/articles/{id} [get]
 func (app *application) getArticleByID(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	article := getArticleFromCtx(r)
+	viewer := getUserFromContext(r)
+
+	v, err := parseView(r, article, articleIncludes)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	// tags always come with a single article
+	if !v.includes(includeTags) {
+		v.include = append(v.include, includeTags)
+	}
+
+	ctx := r.Context()
+	articles := []*store.Article{article}
+	if err := app.setViewerFields(ctx, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.includeArticleResources(ctx, v, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
 
-	article, err := app.store.Articles.GetByID(r.Context(), int(id))
+	resp, err := v.project(article)
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			app.notFoundResponse(w, r, err)
-			return
-		default:
-			app.internalServerError(w, r, err)
-			return
-		}
+		app.internalServerError(w, r, err)
+		return
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, article); err != nil {
+	// the author changes without a new version of the article, the body ETag covers it.
+	// No Last-Modified, likes and comments are counted without touching updated_at
+	if !v.includes(includeAuthor) {
+		w.Header().Set("ETag", articleViewerETag(article, viewer))
+	}
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
 // @Summary		Get articles by user id
-// @Description	Get articles by user id
+// @Description	Get published articles by user id, the author also gets the drafts
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Param			id		path		int	true	"User ID"
-// @Param			offset	query		int	true	"Offset"
-// @Param			limit	query		int	true	"limit"
-// @Success		200		{object}	[]store.Article
-// @Failure		400		{object}	error
-// @Failure		404		{object}	error
-// @Failure		500		{object}	error
-// @Security		ApiKeyAuth
+// @Param			id		path		int		true	"User ID"
+// @Param			offset	query		int		false	"Offset"
+// @Param			limit	query		int		false	"Limit, 10 by default"
+// @Param			count	query		bool	false	"Return the total"
+// @Param			fields	query		string	false	"comma separated fields to return"
+// @Param			include	query		string	false	"comma separated of author, comments, tags"
+// @Success		200		{object}	ListResponse{items=[]store.Article}
+// @Header			200		{string}	Link	"first, prev and next pages"
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		404		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Router			/articles/author/{id} [get]
 func (app *application) getArticlesByUserID(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 32)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
-	pq := store.PaginatedQuery{}
-	if pq, err = pq.Parse(r); err != nil {
+	page, err := parseListPage(r)
+	if err != nil {
 		app.badRequestResponse(w, r, err)
+		return
 	}
+	v, err := parseView(r, store.Article{}, articleIncludes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	viewer := getUserFromContext(r)
+	withDrafts := viewer != nil && viewer.ID == int(userID)
 
-	articles, err := app.store.Articles.GetByAuthor(r.Context(), int(userID), pq)
+	ctx := r.Context()
+	articles, err := app.store.Articles.GetByAuthor(ctx, int(userID), page.fetch(), withDrafts)
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			app.notFoundResponse(w, r, err)
-			return
-		default:
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+	articles, hasMore := pageItems(articles, page)
+
+	var total *int
+	if page.count {
+		n, err := app.store.Articles.CountByAuthor(ctx, int(userID), withDrafts)
+		if err != nil {
 			app.internalServerError(w, r, err)
 			return
 		}
+		total = &n
+	}
+
+	if err := app.setViewerFields(ctx, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.includeArticleResources(ctx, v, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
 	}
-	if err := app.jsonResponse(w, http.StatusOK, articles); err != nil {
+
+	items, err := projectAll(v, articles)
+	if err != nil {
 		app.internalServerError(w, r, err)
+		return
 	}
+	app.listResponse(w, r, items, page, hasMore, total)
 }
 
 // @Summary		Create article
@@ -122,8 +177,9 @@ func (app *application) getArticlesByUserID(w http.ResponseWriter, r *http.Reque
 // @Produce		json
 // @Param			article	body		CreateArticlePayload	true	"Article"
 // @Success		201		{object}	store.Article
-// @Failure		400		{object}	error
-// @Failure		500		{object}	error
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Security		ApiKeyAuth
 // @Router			/articles [post]
 func (app *application) createArticleHandler(w http.ResponseWriter, r *http.Request) {
@@ -144,14 +200,17 @@ func (app *application) createArticleHandler(w http.ResponseWriter, r *http.Requ
 		Title:    payload.Title,
 		Content:  payload.Content,
 		AuthorID: user.ID,
+		Status:   payload.Status,
+		Tags:     normalizeTags(payload.Tags),
 	}
 
 	ctx := r.Context()
 	id, err := app.store.Articles.Create(ctx, article)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		app.storeErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.articlesCreated.Inc()
 
 	article.ID = id
 	if err := app.jsonResponse(w, http.StatusCreated, article); err != nil {
@@ -163,18 +222,27 @@ func (app *application) createArticleHandler(w http.ResponseWriter, r *http.Requ
 type UpdateArticlePayload struct {
 	Title   string `json:"title" validate:"omitempty,max=100"`
 	Content string `json:"content" validate:"omitempty,max=1000"`
+	Status  string `json:"status" validate:"omitempty,oneof=draft published"`
+	// replaces the tags when present, [] removes them
+	Tags []string `json:"tags" validate:"omitempty,max=10,dive,max=50"`
+	// alternative to If-Match header
+	Version int `json:"version" validate:"omitempty,gte=1"`
 }
 
 // @Summary		Update article
-// @Description	Update article
+// @Description	Update article. Current version is required in If-Match header (ETag of the article) or in payload.
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Param			id		path		int						true	"Article ID"
-// @Param			article	body		UpdateArticlePayload	true	"Article"
-// @Success		200		{object}	int
-// @Failure		400		{object}	error
-// @Failure		500		{object}	error
+// @Param			id			path		int						true	"Article ID"
+// @Param			If-Match	header		string					false	"ETag of the article"
+// @Param			article		body		UpdateArticlePayload	true	"Article"
+// @Success		200			{object}	int
+// @Failure		400			{object}	Problem
+// @Failure		404			{object}	Problem
+// @Failure		412			{object}	store.Article	"article was changed, current state"
+// @Failure		428			{object}	Problem
+// @Failure		500			{object}	Problem
 // @Security		ApiKeyAuth
 // @Router			/articles/{id} [patch]
 func (app *application) updateArticleHandler(w http.ResponseWriter, r *http.Request) {
@@ -191,49 +259,63 @@ func (app *application) updateArticleHandler(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if !app.checkArticleVersion(w, r, article, payload.Version) {
+		return
+	}
+
 	if payload.Title != "" {
 		article.Title = payload.Title
 	}
 	if payload.Content != "" {
 		article.Content = payload.Content
 	}
+	if payload.Status != "" {
+		article.Status = payload.Status
+	}
+	if payload.Tags != nil {
+		article.Tags = normalizeTags(payload.Tags)
+	}
 
 	ctx := r.Context()
 	id, err := app.store.Articles.Update(ctx, article)
-	app.logger.Infow("info", "art", article)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		app.articleWriteError(w, r, article.ID, err)
+		return
 	}
 
+	w.Header().Set("ETag", articleETag(article.Version))
 	if err := app.jsonResponse(w, http.StatusOK, id); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
 
 // @Summary		Delete article
-// @Description	Delete article
+// @Description	Delete article. Current version is required in If-Match header (ETag of the article).
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Param			id	path	int	true	"Article ID"
+// @Param			id			path	int		true	"Article ID"
+// @Param			If-Match	header	string	true	"ETag of the article"
 // @Success		204
-// @Failure		400	{object}	error
-// @Failure		404	{object}	error
-// @Failure		500	{object}	error
+// @Failure		400	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		412	{object}	store.Article	"article was changed, current state"
+// @Failure		428	{object}	Problem
+// @Failure		500	{object}	Problem
 // @Security		ApiKeyAuth
 // @Router			/articles/{id} [delete]
 func (app *application) deleteArticleHandler(w http.ResponseWriter, r *http.Request) {
 	article := getArticleFromCtx(r)
 
+	if !app.checkArticleVersion(w, r, article, 0) {
+		return
+	}
+
 	ctx := r.Context()
 
-	if err := app.store.Articles.Delete(ctx, article.ID); err != nil {
-		switch {
-		case errors.Is(err, store.ErrNotFound):
-			app.notFoundResponse(w, r, err)
-		default:
-			app.internalServerError(w, r, err)
-		}
+	if err := app.store.Articles.Delete(ctx, article.ID, article.Version); err != nil {
+		app.articleWriteError(w, r, article.ID, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -244,41 +326,55 @@ func (app *application) deleteArticleHandler(w http.ResponseWriter, r *http.Requ
 // @Tags			articles
 // @Accept			json
 // @Produce		json
-// @Param			id		path		int	true	"Article ID"
-// @Param			offset	query		int	true	"Offset"
-// @Param			limit	query		int	true	"Limit"
-// @Success		200		{object}	[]store.Comment
-// @Failure		400		{object}	error
-// @Failure		404		{object}	error
-// @Failure		500		{object}	error
-// @Security		ApiKeyAuth
+// @Param			id		path		int		true	"Article ID"
+// @Param			offset	query		int		false	"Offset"
+// @Param			limit	query		int		false	"Limit, 10 by default"
+// @Param			count	query		bool	false	"Return the total"
+// @Param			fields	query		string	false	"comma separated fields to return"
+// @Success		200		{object}	ListResponse{items=[]store.Comment}
+// @Header			200		{string}	Link	"first, prev and next pages"
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		404		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Router			/articles/{id}/comments [get]
 func (app *application) getArticleCommentsHandler(w http.ResponseWriter, r *http.Request) {
-	idParam := chi.URLParam(r, "id")
-	id, err := strconv.ParseInt(idParam, 10, 64)
+	article := getArticleFromCtx(r)
+
+	page, err := parseListPage(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
-
-	pq := store.PaginatedQuery{}
-	pq, err = pq.Parse(r)
+	v, err := parseView(r, store.Comment{}, nil)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	if comments, err := app.store.Articles.GetComments(r.Context(), int(id), pq); err != nil {
+	comments, err := app.store.Articles.GetComments(r.Context(), article.ID, page.fetch())
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
-	} else {
-		app.jsonResponse(w, http.StatusOK, comments)
+	}
+	comments, hasMore := pageItems(comments, page)
+
+	var total *int
+	if page.count {
+		// kept on the article by a trigger, no count query
+		total = &article.CommentsCount
+	}
+
+	items, err := projectAll(v, comments)
+	if err != nil {
+		app.internalServerError(w, r, err)
 		return
 	}
+	app.listResponse(w, r, items, page, hasMore, total)
 }
 
 type CommentOnlyText struct {
-	Text string `json:"text"`
+	Text string `json:"text" validate:"required,max=1000"`
 }
 
 // @Summary		Create comment
@@ -289,8 +385,9 @@ type CommentOnlyText struct {
 // @Param			id		path		int				true	"Article ID"
 // @Param			comment	body		CommentOnlyText	true	"Comment"
 // @Success		201		{object}	int
-// @Failure		400		{object}	error
-// @Failure		500		{object}	error
+// @Failure		400		{object}	Problem
+// @Failure		422		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Security		ApiKeyAuth
 // @Router			/articles/{id}/comments [post]
 func (app *application) createArticleCommentHandler(w http.ResponseWriter, r *http.Request) {
@@ -301,23 +398,29 @@ func (app *application) createArticleCommentHandler(w http.ResponseWriter, r *ht
 		return
 	}
 
-	comm := &store.Comment{}
-
-	err = readJSON(w, r, comm)
-	if err != nil {
-		app.internalServerError(w, r, err)
+	var payload CommentOnlyText
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	comm.ArticleID = int(id)
 	user := getUserFromContext(r)
-	comm.UserID = user.ID
+	comm := &store.Comment{
+		ArticleID: int(id),
+		UserID:    user.ID,
+		Text:      payload.Text,
+	}
 
 	commID, err := app.store.Articles.AddComment(r.Context(), comm)
 	if err != nil {
-		app.internalServerError(w, r, err)
+		app.storeErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.commentsCreated.Inc()
 
 	app.jsonResponse(w, http.StatusCreated, commID)
 }
@@ -329,8 +432,10 @@ func (app *application) createArticleCommentHandler(w http.ResponseWriter, r *ht
 // @Produce		json
 // @Param			id	path	int	true	"Article ID"
 // @Success		201
-// @Failure		400	{object}	error
-// @Failure		500	{object}	error
+// @Failure		400	{object}	Problem
+// @Failure		409	{object}	Problem
+// @Failure		422	{object}	Problem
+// @Failure		500	{object}	Problem
 // @Security		ApiKeyAuth
 // @Router			/articles/{id}/like [post]
 func (app *application) createLikeOnArticle(w http.ResponseWriter, r *http.Request) {
@@ -344,9 +449,10 @@ func (app *application) createLikeOnArticle(w http.ResponseWriter, r *http.Reque
 	user := getUserFromContext(r)
 
 	if err := app.store.Articles.AddLike(r.Context(), int(id), user.ID); err != nil {
-		app.internalServerError(w, r, err)
+		app.storeErrorResponse(w, r, err)
 		return
 	}
+	app.metrics.articleLikes.Inc()
 
 	if err := app.jsonResponse(w, http.StatusCreated, nil); err != nil {
 		app.internalServerError(w, r, err)
@@ -354,6 +460,75 @@ func (app *application) createLikeOnArticle(w http.ResponseWriter, r *http.Reque
 	}
 }
 
+// @Summary		Bookmark article
+// @Description	Bookmark article for the current user
+// @Tags			articles
+// @Param			id	path	int	true	"Article ID"
+// @Success		201
+// @Failure		401	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		409	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/articles/{id}/bookmark [post]
+func (app *application) createBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	article := getArticleFromCtx(r)
+	user := getUserFromContext(r)
+
+	if err := app.store.Articles.AddBookmark(r.Context(), article.ID, user.ID); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// @Summary		Remove bookmark
+// @Description	Remove bookmark of the current user from article
+// @Tags			articles
+// @Param			id	path	int	true	"Article ID"
+// @Success		204
+// @Failure		401	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/articles/{id}/bookmark [delete]
+func (app *application) deleteBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	article := getArticleFromCtx(r)
+	user := getUserFromContext(r)
+
+	if err := app.store.Articles.DeleteBookmark(r.Context(), article.ID, user.ID); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setViewerFields fills liked_by_me, bookmarked_by_me and can_edit with one query,
+// articles of anonymous requests are left without them
+func (app *application) setViewerFields(ctx context.Context, viewer *store.User, articles []*store.Article) error {
+	if viewer == nil || len(articles) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(articles))
+	for i, art := range articles {
+		ids[i] = art.ID
+	}
+	states, err := app.store.Articles.GetViewerStates(ctx, viewer.ID, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, art := range articles {
+		state := states[art.ID]
+		liked, bookmarked, canEdit := state.Liked, state.Bookmarked, art.AuthorID == viewer.ID
+		art.LikedByMe, art.BookmarkedByMe, art.CanEdit = &liked, &bookmarked, &canEdit
+	}
+	return nil
+}
+
 func (app *application) articleContextMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		idParam := chi.URLParam(r, "id")
@@ -367,14 +542,16 @@ func (app *application) articleContextMiddleware(next http.Handler) http.Handler
 
 		article, err := app.store.Articles.GetByID(ctx, int(id))
 		if err != nil {
-			switch {
-			case errors.Is(err, store.ErrNotFound):
-				app.notFoundResponse(w, r, err)
-			default:
-				app.internalServerError(w, r, err)
-			}
+			app.storeErrorResponse(w, r, err)
 			return
 		}
+		// drafts do not exist for anyone but the author
+		if article.Status == store.ArticleDraft {
+			if user := getUserFromContext(r); user == nil || user.ID != article.AuthorID {
+				app.notFoundResponse(w, r, store.ErrNotFound)
+				return
+			}
+		}
 
 		ctx = context.WithValue(ctx, articleCtx, article)
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
+	"github.com/critma/goblog/internal/i18n"
 	"github.com/critma/goblog/internal/store"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var errInvalidCredentials = i18n.Error("auth.invalid_credentials")
+
 type ToRegisterPayload struct {
 	Username string `json:"username" validate:"required,max=100"`
 	Email    string `json:"email" validate:"required,email,max=255"`
@@ -21,10 +25,17 @@ type ToRegisterPayload struct {
 // @Produce		json
 // @Param			user	body		ToRegisterPayload	true	"User"
 // @Success		204		{object}	nil
-// @Failure		400		{object}	error
-// @Failure		500		{object}	error
+// @Failure		400		{object}	Problem
+// @Failure		403		{object}	Problem
+// @Failure		409		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Router			/auth/reg [post]
 func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.config().features.registration {
+		app.forbiddenResponse(w, r, i18n.Error("auth.registration_disabled"))
+		return
+	}
+
 	var payload ToRegisterPayload
 	if err := readJSON(w, r, &payload); err != nil {
 		app.badRequestResponse(w, r, err)
@@ -41,16 +52,21 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		Email:    payload.Email,
 	}
 
-	if err := user.Password.Set(payload.Password); err != nil {
+	ctx := r.Context()
+
+	_, span := app.tracer.Start(ctx, "bcrypt.hash")
+	err := user.Password.Set(payload.Password)
+	span.End()
+	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
 
-	ctx := r.Context()
-
 	if err := app.store.Users.Create(ctx, user); err != nil {
-		app.internalServerError(w, r, err)
+		app.storeErrorResponse(w, r, err)
+		return
 	}
+	app.metrics.usersRegistered.Inc()
 
 	app.jsonResponse(w, http.StatusNoContent, nil)
 }
@@ -58,18 +74,22 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 type ToLoginPayload struct {
 	Email    string `json:"email" validate:"required,email,max=255"`
 	Password string `json:"password" validate:"required,min=7,max=72"`
+	// start a cookie session instead of returning a bearer token
+	Session bool `json:"session"`
 }
 
 // @Summary		Login user
-// @Description	Login user
+// @Description	Login user. Users with two-factor authentication get a challenge token for /auth/2fa/verify instead.
+// @Description	Repeated failures slow down and temporarily lock logins of the account and the client ip.
 // @Tags			auth
 // @Accept			json
 // @Produce		json
 // @Param			user	body		ToLoginPayload	true	"User"
-// @Success		202		{object}	string
-// @Failure		400		{object}	error
-// @Failure		404		{object}	error
-// @Failure		500		{object}	error
+// @Success		202		{object}	string			"token, CookieLogin with session, or TwoFactorChallenge when two-factor authentication is enabled"
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		429		{object}	Problem
+// @Failure		500		{object}	Problem
 // @Router			/auth/log [post]
 func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request) {
 	var payload ToLoginPayload
@@ -83,36 +103,110 @@ func (app *application) loginUserHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	user, err := app.store.Users.GetByEmail(r.Context(), payload.Email)
+	ctx := r.Context()
+	keys := newLoginKeys(r, payload.Email)
+
+	attempt, wait, err := app.reserveLogin(ctx, keys)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+
+	user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		// the credentials were not checked
+		app.internalServerError(w, r, errors.Join(err, app.releaseLogin(ctx, attempt)))
+		return
+	}
+
+	// unknown emails fail the same way and take the same time as wrong passwords
+	_, span := app.tracer.Start(ctx, "bcrypt.compare")
+	if user == nil {
+		_ = dummyUser.Password.CompareWithHash(payload.Password)
+		err = errInvalidCredentials
+	} else if err = user.Password.CompareWithHash(payload.Password); err != nil {
+		err = errInvalidCredentials
+	}
+	span.End()
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			app.notFoundResponse(w, r, err)
-		default:
+		if err := app.loginFailed(r, attempt, user); err != nil {
 			app.internalServerError(w, r, err)
+			return
 		}
+		app.unauthorizedErrorResponse(w, r, err)
+		return
 	}
 
-	if err := user.Password.CompareWithHash(payload.Password); err != nil {
-		app.unauthorizedErrorResponse(w, r, err)
+	if user.TwoFactor.Enabled {
+		app.twoFactorChallengeResponse(w, r, user, attempt)
+		return
+	}
+
+	if err := app.loginSucceeded(ctx, attempt); err != nil {
+		app.internalServerError(w, r, err)
 		return
 	}
 
+	app.completeLogin(w, r, user, payload.Session)
+}
+
+func (app *application) issueAuthToken(user *store.User) (string, error) {
 	claims := jwt.MapClaims{
 		"sub": user.ID,
-		"exp": time.Now().Add(time.Hour * 24).Unix(),
+		"exp": time.Now().Add(app.config().auth.exp).Unix(),
 		"iat": time.Now().Unix(),
 		"nbf": time.Now().Unix(),
-		"iss": app.config.auth.issuer,
-		"aud": app.config.auth.issuer,
+		"iss": app.config().auth.issuer,
+		"aud": app.config().auth.issuer,
 	}
 
-	token, err := app.authenticator.GenerateToken(claims)
+	return app.authenticator.GenerateToken(claims)
+}
+
+// twoFactorChallengeResponse answers the first factor with a challenge token for /auth/2fa/verify,
+// failures of the account are kept until the second factor is passed too
+func (app *application) twoFactorChallengeResponse(w http.ResponseWriter, r *http.Request, user *store.User, attempt *loginReservation) {
+	if err := app.releaseLogin(r.Context(), attempt); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	challenge, err := app.issueChallengeToken(user)
 	if err != nil {
 		app.internalServerError(w, r, err)
 		return
 	}
-	if err := app.jsonResponse(w, http.StatusAccepted, token); err != nil {
+	resp := TwoFactorChallenge{
+		ChallengeToken: challenge,
+		ExpiresIn:      int(app.config().auth.challengeExp.Seconds()),
+	}
+	if err := app.jsonResponse(w, http.StatusAccepted, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// challenge token only grants access to the second login step
+func (app *application) issueChallengeToken(user *store.User) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":   user.ID,
+		"exp":   time.Now().Add(app.config().auth.challengeExp).Unix(),
+		"iat":   time.Now().Unix(),
+		"nbf":   time.Now().Unix(),
+		"iss":   app.config().auth.issuer,
+		"aud":   app.config().auth.issuer,
+		"scope": twoFactorScope,
+	}
+
+	return app.authenticator.GenerateToken(claims)
+}
+
+// public keys for verifying issued tokens, served at the well-known location outside of /api/v1
+func (app *application) getJWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := writeJSON(w, http.StatusOK, app.authenticator.JWKS()); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/critma/goblog/internal/store/cache"
+)
+
+// @Summary		Cache statistics
+// @Description	Hits, misses, evictions and size of the user and article caches
+// @Tags			admin
+// @Produce		json
+// @Success		200	{object}	map[string]cache.Stats
+// @Failure		403	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/admin/cache [get]
+func (app *application) getCacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]cache.Stats{}
+	if app.cache != nil {
+		stats = app.cache.Stats()
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, stats); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
@@ -1,35 +1,236 @@
 package main
 
 import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/ratelimit"
+	"github.com/critma/goblog/internal/settings"
 	"github.com/critma/goblog/internal/store"
+	"github.com/critma/goblog/internal/store/cache"
+	"github.com/critma/goblog/internal/tracing"
+	"github.com/critma/goblog/internal/webauthn"
 	"go.uber.org/zap"
 )
 
 type application struct {
-	config        config
+	// swapped by reloadConfig, read through config()
+	cfg atomic.Pointer[config]
+	// effective settings of cfg, guarded by reloadMu
+	settings   *settings.Set
+	configArgs []string
+	reloadMu   sync.Mutex
+
 	logger        *zap.SugaredLogger
+	logLevel      zap.AtomicLevel
 	store         store.Storage
 	authenticator auth.Authenticator
+	webauthn      *webauthn.Config
+	rateLimiter   ratelimit.Store
+	// nil when caching is disabled
+	cache *cache.Cache
+
+	// background tasks started by requests
+	tasks   sync.WaitGroup
+	workers *workerGroup
+
+	// used by readiness checks only, handlers go through store
+	db *sql.DB
+	// readiness fails from the start of shutdown
+	shuttingDown atomic.Bool
+
+	metrics *appMetrics
+	tracer  *tracing.Tracer
 }
 
 type config struct {
-	addr string
-	db   dbConfig
-	auth authConfig
+	// "development" or "production", production refuses weak secrets
+	mode   string
+	log    logConfig
+	server serverConfig
+	db     dbConfig
+	auth   authConfig
+	// cookie logins of browsers
+	session sessionConfig
+
+	webauthn  webauthnConfig
+	cors      corsConfig
+	security  securityConfig
+	limits    limitsConfig
+	rateLimit rateLimitConfig
+	lockout   lockoutConfig
+	mail      mailConfig
+	cache     cacheConfig
+	httpCache httpCacheConfig
+	tracing   tracingConfig
+	features  featuresConfig
+}
+
+type logConfig struct {
+	level string
+}
+
+type serverConfig struct {
+	addr              string
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	// handlers are cancelled and answer 503 after this
+	handlerTimeout time.Duration
+	// internal listener of /metrics, kept off the public address
+	metricsAddr string
+
+	// how long shutdown waits for requests and workers before dropping them
+	shutdownTimeout time.Duration
+	// pause between failing readiness and closing the listener, so load balancers stop routing
+	shutdownDelay time.Duration
 }
 
 type dbConfig struct {
 	addr         string
 	maxOpenConns int
 	maxIdleConns int
-	maxIdleTime  string
+	maxIdleTime  time.Duration
+	// how long startup retries to connect
+	connectTimeout time.Duration
+	// pending schema migrations are applied at startup
+	migrate bool
 }
 
 type authConfig struct {
-	secret string
-	issuer string
-	exp    time.Duration
+	// hmac signing key, with keysDir set it only verifies tokens issued before secretRetiredAt
+	secret          string
+	secretRetiredAt time.Time
+	keysDir         string
+	issuer          string
+	// lifetime of access tokens
+	exp time.Duration
+	// lifetime of the challenge token between password and second factor
+	challengeExp time.Duration
+	// roles which must use two-factor authentication
+	twoFactorRoles []string
+	// base64 AES-256 key of TOTP secrets in the database
+	totpKey string
+}
+
+type sessionConfig struct {
+	ttl time.Duration
+	// cookies are only sent over https, disabled for local development over http
+	cookieSecure bool
+	// lax, strict or none
+	sameSite string
+}
+
+type webauthnConfig struct {
+	rpID    string
+	rpName  string
+	origins []string
+}
+
+type corsConfig struct {
+	// browser origins allowed to call the api, "*" allows any,
+	// "https://*.example.com" any subdomain of example.com
+	allowedOrigins []string
+	allowedMethods []string
+	// "*" allows any requested header
+	allowedHeaders   []string
+	allowCredentials bool
+	// how long browsers cache preflight results, 0 leaves it to the browser
+	maxAge time.Duration
+}
+
+type securityConfig struct {
+	// sent only to requests which came over tls, 0 disables the header
+	hstsMaxAge            time.Duration
+	hstsIncludeSubdomains bool
+	// policy of api responses and of html pages (swagger ui),
+	// frame-ancestors is appended to both
+	contentSecurityPolicy     string
+	htmlContentSecurityPolicy string
+	frameAncestors            []string
+	referrerPolicy            string
+}
+
+type limitsConfig struct {
+	// max size of json request bodies
+	maxBodyBytes int
+}
+
+type featuresConfig struct {
+	// sign-ups are allowed
+	registration bool
+}
+
+type rateLimitConfig struct {
+	// "memory" or "postgres" to share limits between instances
+	store string
+	// whole api by ip
+	global ratelimit.Limit
+	// login and registration by ip
+	auth ratelimit.Limit
+	// comments by user
+	comments ratelimit.Limit
+}
+
+// limit of a RateLimitMiddleware group
+func (c rateLimitConfig) limit(name string) ratelimit.Limit {
+	switch name {
+	case "global":
+		return c.global
+	case "auth":
+		return c.auth
+	case "comments":
+		return c.comments
+	}
+	panic("unknown rate limit group " + name)
+}
+
+type lockoutConfig struct {
+	// failed logins of one email
+	account auth.LockoutPolicy
+	// failed logins from one ip, looser as clients may share an address
+	ip auth.LockoutPolicy
+}
+
+type mailConfig struct {
+	// mails are only logged when host is empty
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
+	from         string
+}
+
+type cacheConfig struct {
+	// max entries per entity, 0 disables the cache
+	size int
+	ttl  time.Duration
+}
+
+// Cache-Control of GET responses per route, empty sends none
+type httpCacheConfig struct {
+	article  string
+	comments string
+	users    string
+	// latest articles
+	feed           string
+	authorArticles string
+	articleList    string
+}
+
+type tracingConfig struct {
+	// "otlp", "file" or empty to only generate trace ids
+	exporter     string
+	otlpEndpoint string
+	file         string
+	serviceName  string
+}
+
+// config is the current configuration, a snapshot which must not be modified
+func (app *application) config() *config {
+	return app.cfg.Load()
 }
@@ -1,27 +1,128 @@
 package main
 
-import "net/http"
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/critma/goblog/internal/store"
+)
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("bad request", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.requestLogger(r).Warnw("bad request", "error", err.Error())
 
-	writeJSONError(w, http.StatusBadRequest, err.Error())
+	// decoding and validation errors are described per field instead of validator messages
+	if p := requestProblem(r, err); p != nil {
+		writeProblem(w, p)
+		return
+	}
+	writeProblem(w, newProblem(r, http.StatusBadRequest, problemBadRequest, errorDetail(r, err, "problem.bad_request")))
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("not found error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.requestLogger(r).Warnw("not found error", "error", err.Error())
 
-	writeJSONError(w, http.StatusNotFound, "not found")
+	writeProblem(w, newProblem(r, http.StatusNotFound, problemNotFound, errorDetail(r, err, "")))
 }
 
 func (app *application) internalServerError(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Errorw("internal error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.requestLogger(r).Errorw("internal error", "error", err.Error())
 
-	writeJSONError(w, http.StatusInternalServerError, "the server encountered a problem")
+	writeProblem(w, newProblem(r, http.StatusInternalServerError, problemInternal, localize(r, "problem.internal")))
 }
 
 func (app *application) unauthorizedErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.logger.Warnf("unauthorized error", "method", r.Method, "path", r.URL.Path, "error", err.Error())
+	app.requestLogger(r).Warnw("unauthorized error", "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusUnauthorized, problemUnauthorized, errorDetail(r, err, "")))
+}
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLogger(r).Warnw("forbidden error", "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusForbidden, problemForbidden, errorDetail(r, err, "")))
+}
+
+func (app *application) conflictResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLogger(r).Warnw("conflict error", "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusConflict, problemConflict, errorDetail(r, err, "")))
+}
+
+// constraintViolationResponse names the field that broke a database constraint,
+// duplicates are conflicts, missing references and disallowed values are unprocessable
+func (app *application) constraintViolationResponse(w http.ResponseWriter, r *http.Request, err *store.ConstraintError) {
+	app.requestLogger(r).Warnw("constraint violation", "constraint", err.Constraint, "error", err.Error())
+
+	status, typ, code := http.StatusUnprocessableEntity, problemUnprocessable, "invalid_value"
+	switch {
+	case errors.Is(err, store.ErrExists):
+		status, typ, code = http.StatusConflict, problemConflict, "already_exists"
+	case errors.Is(err, store.ErrInvalidReference):
+		code = "invalid_reference"
+	}
+
+	// well known constraints have their own message
+	detail := localize(r, "constraint."+err.Constraint)
+	if strings.HasPrefix(detail, "constraint.") {
+		detail = localize(r, "problem."+code)
+	}
+
+	p := newProblem(r, status, typ, detail)
+	if err.Field != "" {
+		p.Errors = []FieldError{{Field: err.Field, Code: code, Detail: localize(r, "field."+code)}}
+	}
+	writeProblem(w, p)
+}
+
+// storeErrorResponse answers a failed store call, constraint violations and missing rows
+// are described to the client, other errors are internal
+func (app *application) storeErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	var constraintErr *store.ConstraintError
+	switch {
+	case errors.As(err, &constraintErr):
+		app.constraintViolationResponse(w, r, constraintErr)
+	case errors.Is(err, store.ErrNotFound):
+		app.notFoundResponse(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	app.requestLogger(r).Warnw("rate limit exceeded", "remote_addr", clientIP(r))
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeProblem(w, newProblem(r, http.StatusTooManyRequests, problemRateLimited, localize(r, "problem.rate_limited")))
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	app.requestLogger(r).Warnw("login locked", "remote_addr", clientIP(r))
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeProblem(w, newProblem(r, http.StatusTooManyRequests, problemLoginLocked, localize(r, "problem.login_locked")))
+}
+
+func (app *application) preconditionRequiredResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.requestLogger(r).Warnw("precondition required", "error", err.Error())
+
+	writeProblem(w, newProblem(r, http.StatusPreconditionRequired, problemPreconditionRequired, errorDetail(r, err, "")))
+}
+
+// responds with the current state of the resource, so the client can merge its changes
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request, err error, current any) {
+	app.requestLogger(r).Warnw("precondition failed", "error", err.Error())
+
+	p := newProblem(r, http.StatusPreconditionFailed, problemPreconditionFailed, errorDetail(r, err, ""))
+	p.Data = current
+	writeProblem(w, p)
+}
+
+func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	app.requestLogger(r).Warnw("method not allowed")
 
-	writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+	writeProblem(w, newProblem(r, http.StatusMethodNotAllowed, problemMethodNotAllowed, localize(r, "problem.method_not_allowed", r.Method)))
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+)
+
+const (
+	includeAuthor   = "author"
+	includeComments = "comments"
+	includeTags     = "tags"
+
+	// newest comments embedded per article
+	includedComments = 3
+)
+
+var articleIncludes = []string{includeAuthor, includeComments, includeTags}
+
+// view is the shape of a response asked for by ?fields and ?include
+type view struct {
+	// json fields to keep, nil keeps all of them
+	fields  []string
+	include []string
+}
+
+// parseView checks ?fields against the json fields of model and ?include against includes,
+// included resources are kept without being listed in fields
+func parseView(r *http.Request, model any, includes []string) (view, error) {
+	var v view
+	q := r.URL.Query()
+
+	if include := q.Get("include"); include != "" {
+		for _, name := range strings.Split(include, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(includes, name) {
+				return v, i18n.Error("view.invalid_include", name, strings.Join(includes, ", "))
+			}
+			if !slices.Contains(v.include, name) {
+				v.include = append(v.include, name)
+			}
+		}
+	}
+
+	if fields := q.Get("fields"); fields != "" {
+		known := jsonFields(reflect.TypeOf(model))
+		v.fields = slices.Clone(v.include)
+		for _, name := range strings.Split(fields, ",") {
+			name = strings.TrimSpace(name)
+			if !slices.Contains(known, name) {
+				return v, i18n.Error("view.invalid_field", name)
+			}
+			v.fields = append(v.fields, name)
+		}
+	}
+
+	return v, nil
+}
+
+func (v view) includes(name string) bool {
+	return slices.Contains(v.include, name)
+}
+
+// project keeps the asked fields of item, item is returned as it is without ?fields
+func (v view) project(item any) (any, error) {
+	if v.fields == nil {
+		return item, nil
+	}
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]json.RawMessage, len(v.fields))
+	for _, name := range v.fields {
+		if raw, ok := all[name]; ok {
+			result[name] = raw
+		}
+	}
+	return result, nil
+}
+
+func projectAll[T any](v view, items []T) (any, error) {
+	if v.fields == nil {
+		return items, nil
+	}
+
+	result := make([]any, len(items))
+	for i, item := range items {
+		projected, err := v.project(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = projected
+	}
+	return result, nil
+}
+
+// top level json names of a struct, the allowlist of ?fields
+func jsonFields(t reflect.Type) []string {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		if name := jsonFieldName(f); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// includeArticleResources embeds the included resources of articles, one query per kind of resource
+func (app *application) includeArticleResources(ctx context.Context, v view, viewer *store.User, articles []*store.Article) error {
+	if len(articles) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(articles))
+	authorIDs := make([]int, 0, len(articles))
+	for i, art := range articles {
+		ids[i] = art.ID
+		if !slices.Contains(authorIDs, art.AuthorID) {
+			authorIDs = append(authorIDs, art.AuthorID)
+		}
+	}
+
+	if v.includes(includeAuthor) {
+		authors, err := app.store.Users.GetByIDs(ctx, authorIDs)
+		if err != nil {
+			return err
+		}
+		for _, art := range articles {
+			if author, ok := authors[art.AuthorID]; ok {
+				art.Author = publicAuthor(author, viewer)
+			}
+		}
+	}
+
+	if v.includes(includeComments) {
+		comments, err := app.store.Articles.GetLatestComments(ctx, ids, includedComments)
+		if err != nil {
+			return err
+		}
+		for _, art := range articles {
+			art.Comments = comments[art.ID]
+		}
+	}
+
+	if v.includes(includeTags) {
+		tags, err := app.store.Articles.GetTags(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for _, art := range articles {
+			art.Tags = tags[art.ID]
+		}
+	}
+
+	return nil
+}
+
+// publicAuthor is the author as the viewer may see it, the email is shown to the author and admins
+func publicAuthor(user, viewer *store.User) *store.Author {
+	author := &store.Author{ID: user.ID, Username: user.Username}
+	if canSeeEmail(user, viewer) {
+		author.Email = user.Email
+	}
+	return author
+}
+
+func canSeeEmail(user, viewer *store.User) bool {
+	return viewer != nil && (viewer.ID == user.ID || viewer.Role == store.RoleAdmin)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+func TestPublicAuthor(t *testing.T) {
+	author := &store.User{ID: 1, Username: "author", Email: "author@example.com", Role: store.RoleUser}
+
+	tests := []struct {
+		name      string
+		viewer    *store.User
+		wantEmail string
+	}{
+		{name: "anonymous"},
+		{name: "other user", viewer: &store.User{ID: 2, Role: store.RoleUser}},
+		{name: "moderator", viewer: &store.User{ID: 3, Role: store.RoleModerator}},
+		{name: "the author", viewer: &store.User{ID: 1, Role: store.RoleUser}, wantEmail: author.Email},
+		{name: "admin", viewer: &store.User{ID: 4, Role: store.RoleAdmin}, wantEmail: author.Email},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := publicAuthor(author, tt.viewer)
+			want := store.Author{ID: 1, Username: "author", Email: tt.wantEmail}
+			if *got != want {
+				t.Errorf("publicAuthor = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/critma/goblog/internal/store/postgres"
+)
+
+const (
+	healthOK     = "ok"
+	healthFailed = "failed"
+
+	readinessCheckTimeout = time.Second * 2
+)
+
+type healthCheck struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// @Summary		Liveness
+// @Description	Process is alive and serving requests
+// @Tags			health
+// @Produce		json
+// @Success		200	{object}	healthReport
+// @Router			/healthz [get]
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, http.StatusOK, healthReport{Status: healthOK}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Readiness
+// @Description	Instance can serve traffic: database answers, schema is up to date, workers run and it is not shutting down
+// @Tags			health
+// @Produce		json
+// @Success		200	{object}	healthReport
+// @Failure		503	{object}	healthReport
+// @Router			/readyz [get]
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	report := healthReport{Status: healthOK, Checks: map[string]healthCheck{
+		"shutdown":   runCheck(ctx, app.checkNotShuttingDown),
+		"database":   runCheck(ctx, app.checkDatabase),
+		"migrations": runCheck(ctx, app.checkMigrations),
+		"workers":    runCheck(ctx, app.checkWorkers),
+	}}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != healthOK {
+			report.Status = healthFailed
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, status, report); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func runCheck(ctx context.Context, check func(context.Context) error) healthCheck {
+	start := time.Now()
+	err := check(ctx)
+
+	res := healthCheck{Status: healthOK, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		res.Status = healthFailed
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func (app *application) checkNotShuttingDown(context.Context) error {
+	if app.shuttingDown.Load() {
+		return fmt.Errorf("shutting down")
+	}
+	return nil
+}
+
+func (app *application) checkDatabase(ctx context.Context) error {
+	return app.db.PingContext(ctx)
+}
+
+func (app *application) checkMigrations(ctx context.Context) error {
+	version, err := postgres.CurrentSchemaVersion(ctx, app.db)
+	if err != nil {
+		return err
+	}
+	if version < postgres.SchemaVersion {
+		return fmt.Errorf("schema version %d, pending migrations up to %d", version, postgres.SchemaVersion)
+	}
+	return nil
+}
+
+func (app *application) checkWorkers(context.Context) error {
+	if stopped := app.workers.stopped(); len(stopped) > 0 {
+		return fmt.Errorf("not running: %s", strings.Join(stopped, ", "))
+	}
+	return nil
+}
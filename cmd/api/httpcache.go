@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// ConditionalGetMiddleware tags successful GET responses with a strong ETag of the body
+// and answers 304 Not Modified to If-None-Match or If-Modified-Since of an unchanged resource.
+// Handlers may set ETag (e.g. from a version column) and Last-Modified themselves.
+// cacheControl is sent with successful responses only, empty means none.
+func (app *application) ConditionalGetMiddleware(cacheControl string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bw := &bufferedResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(bw, r)
+
+			if bw.status != 0 && bw.status != http.StatusOK {
+				bw.flush()
+				return
+			}
+
+			h := w.Header()
+			if h.Get("ETag") == "" {
+				h.Set("ETag", bodyETag(bw.body.Bytes()))
+			}
+			if cacheControl != "" {
+				h.Set("Cache-Control", cacheControl)
+			}
+
+			if notModified(r, h) {
+				h.Del("Content-Type")
+				h.Del("Content-Length")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			bw.flush()
+		})
+	}
+}
+
+// keeps the response until the middleware decides between 200 and 304
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.body.Bytes())
+}
+
+func bodyETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// If-None-Match takes precedence, If-Modified-Since is only used without it (RFC 9110 13.2.2)
+func notModified(r *http.Request, h http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, h.Get("ETag"))
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(h.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(ims)
+}
+
+// weak comparison, as required for If-None-Match
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
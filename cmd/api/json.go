@@ -11,6 +11,7 @@ var Validate *validator.Validate
 
 func init() {
 	Validate = validator.New(validator.WithRequiredStructEnabled())
+	Validate.RegisterTagNameFunc(jsonFieldName)
 }
 
 func writeJSON(w http.ResponseWriter, status int, data any) error {
@@ -19,12 +20,10 @@ func writeJSON(w http.ResponseWriter, status int, data any) error {
 	return json.NewEncoder(w).Encode(data)
 }
 
-func writeJSONError(w http.ResponseWriter, status int, message string) error {
-	type envelope struct {
-		Error string `json:"error"`
-	}
-
-	return writeJSON(w, status, &envelope{Error: message})
+func writeProblem(w http.ResponseWriter, p *Problem) error {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	return json.NewEncoder(w).Encode(p)
 }
 
 func (app *application) jsonResponse(w http.ResponseWriter, status int, data any) error {
@@ -35,10 +34,8 @@ func (app *application) jsonResponse(w http.ResponseWriter, status int, data any
 	return writeJSON(w, status, &envelope{Data: data})
 }
 
+// body size is limited by BodyLimitMiddleware
 func readJSON(w http.ResponseWriter, r *http.Request, data any) error {
-	maxBytes := 1_100_000
-	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
-
 	decoder := json.NewDecoder(r.Body)
 	decoder.DisallowUnknownFields()
 
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+)
+
+const (
+	defaultListLimit = 10
+	maxSortFields    = 3
+	maxFilterTags    = 5
+)
+
+var errOthersDrafts = i18n.Error("listing.others_drafts")
+
+// ListResponse is the data of list endpoints
+type ListResponse struct {
+	Items any `json:"items"`
+	// with ?count=true only, counting may be slow
+	Total   *int `json:"total,omitempty"`
+	Limit   int  `json:"limit"`
+	Offset  int  `json:"offset"`
+	HasMore bool `json:"has_more"`
+}
+
+// listPage is limit and offset of a list request and whether the total is wanted
+type listPage struct {
+	store.PaginatedQuery
+	count bool
+	// all items are on the page, it has no links
+	fixed bool
+}
+
+// fixedPage is the page of a list which is not paginated, e.g. passkeys of the user
+func fixedPage(n int) listPage {
+	return listPage{PaginatedQuery: store.PaginatedQuery{Limit: n}, fixed: true}
+}
+
+func parseListPage(r *http.Request) (listPage, error) {
+	page := listPage{PaginatedQuery: store.PaginatedQuery{Limit: defaultListLimit}}
+
+	var err error
+	if page.PaginatedQuery, err = page.Parse(r); err != nil {
+		return page, err
+	}
+	if err := Validate.Struct(page.PaginatedQuery); err != nil {
+		return page, err
+	}
+	if count := r.URL.Query().Get("count"); count != "" {
+		if page.count, err = strconv.ParseBool(count); err != nil {
+			return page, i18n.Error("listing.invalid_count")
+		}
+	}
+	return page, nil
+}
+
+// fetch asks the store for one item more than the page, which tells whether there is a next one
+func (p listPage) fetch() store.PaginatedQuery {
+	q := p.PaginatedQuery
+	q.Limit++
+	return q
+}
+
+// pageItems cuts the item fetched beyond the page
+func pageItems[T any](items []T, page listPage) ([]T, bool) {
+	if len(items) > page.Limit {
+		return items[:page.Limit], true
+	}
+	return items, false
+}
+
+// listResponse answers a page of items with the first, prev and next links (RFC 8288),
+// total is nil unless asked for
+func (app *application) listResponse(w http.ResponseWriter, r *http.Request, items any, page listPage, hasMore bool, total *int) {
+	if !page.fixed {
+		links := []string{pageLink(r, page.Limit, 0, "first")}
+		if page.Offset > 0 {
+			links = append(links, pageLink(r, page.Limit, max(page.Offset-page.Limit, 0), "prev"))
+		}
+		if hasMore {
+			links = append(links, pageLink(r, page.Limit, page.Offset+page.Limit, "next"))
+		}
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	resp := ListResponse{
+		Items:   items,
+		Total:   total,
+		Limit:   page.Limit,
+		Offset:  page.Offset,
+		HasMore: hasMore,
+	}
+	if err := app.jsonResponse(w, http.StatusOK, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// the request with another offset, other parameters are kept
+func pageLink(r *http.Request, limit, offset int, rel string) string {
+	u := *r.URL
+	q := u.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	u.RawQuery = q.Encode()
+	return "<" + u.RequestURI() + `>; rel="` + rel + `"`
+}
+
+// parseArticleFilter reads sort and filters of an article listing, every value is checked
+// against an allowlist or parsed into its type before it gets to the store
+func parseArticleFilter(r *http.Request, viewer *store.User) (store.ArticleFilter, error) {
+	q := r.URL.Query()
+	var filter store.ArticleFilter
+
+	if sort := q.Get("sort"); sort != "" {
+		fields := strings.Split(sort, ",")
+		if len(fields) > maxSortFields {
+			return filter, i18n.Error("listing.too_many_sort_fields", maxSortFields)
+		}
+		for _, field := range fields {
+			field = strings.TrimSpace(field)
+			name, desc := strings.CutPrefix(field, "-")
+			if !slices.Contains(store.ArticleSortFields, name) {
+				return filter, i18n.Error("listing.invalid_sort", field, strings.Join(store.ArticleSortFields, ", "))
+			}
+			filter.Sort = append(filter.Sort, store.ArticleSort{Field: name, Desc: desc})
+		}
+	}
+
+	var err error
+	if filter.PublishedAfter, err = parseFilterTime(q.Get("published_after"), "published_after"); err != nil {
+		return filter, err
+	}
+	if filter.PublishedBefore, err = parseFilterTime(q.Get("published_before"), "published_before"); err != nil {
+		return filter, err
+	}
+
+	if author := q.Get("author"); author != "" {
+		id, err := strconv.Atoi(author)
+		if err != nil || id < 1 {
+			return filter, i18n.Error("listing.invalid_author")
+		}
+		filter.AuthorID = id
+	}
+
+	filter.Tags = normalizeTags(q["tag"])
+	if len(filter.Tags) > maxFilterTags {
+		return filter, i18n.Error("listing.too_many_tags", maxFilterTags)
+	}
+
+	switch status := q.Get("status"); status {
+	case "", store.ArticlePublished:
+		filter.Status = store.ArticlePublished
+	case store.ArticleDraft:
+		// drafts of the viewer only
+		if viewer == nil {
+			return filter, errAuthRequired
+		}
+		if filter.AuthorID != 0 && filter.AuthorID != viewer.ID {
+			return filter, errOthersDrafts
+		}
+		filter.AuthorID = viewer.ID
+		filter.Status = store.ArticleDraft
+	default:
+		return filter, i18n.Error("listing.invalid_status")
+	}
+
+	return filter, nil
+}
+
+// a date is its midnight in utc
+func parseFilterTime(value, param string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, i18n.Error("listing.invalid_time", param)
+}
+
+// tags are compared lowercase, duplicates and empty ones are dropped
+func normalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !slices.Contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// @Summary		List articles
+// @Description	List articles with sorting and filters, anonymously or with the viewer fields. Drafts are listed for their author only.
+// @Tags			articles
+// @Produce		json
+// @Param			sort				query		string		false	"comma separated of published_at, likes, comments, updated_at, '-' prefix for descending, -published_at by default"
+// @Param			published_after		query		string		false	"RFC 3339 time or date"
+// @Param			published_before	query		string		false	"RFC 3339 time or date"
+// @Param			author				query		int			false	"User ID"
+// @Param			tag					query		[]string	false	"articles having all of the tags"	collectionFormat(multi)
+// @Param			status				query		string		false	"published (default) or draft"
+// @Param			offset				query		int			false	"Offset"
+// @Param			limit				query		int			false	"Limit, 10 by default"
+// @Param			count				query		bool		false	"Return the total"
+// @Param			fields				query		string		false	"comma separated fields to return"
+// @Param			include				query		string		false	"comma separated of author, comments, tags"
+// @Success		200					{object}	ListResponse{items=[]store.Article}
+// @Header			200					{string}	Link	"first, prev and next pages"
+// @Failure		400					{object}	Problem
+// @Failure		401					{object}	Problem
+// @Failure		403					{object}	Problem
+// @Failure		500					{object}	Problem
+// @Router			/articles/list [get]
+func (app *application) listArticlesHandler(w http.ResponseWriter, r *http.Request) {
+	viewer := getUserFromContext(r)
+
+	filter, err := parseArticleFilter(r, viewer)
+	if err != nil {
+		switch {
+		case errors.Is(err, errAuthRequired):
+			app.unauthorizedErrorResponse(w, r, err)
+		case errors.Is(err, errOthersDrafts):
+			app.forbiddenResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	page, err := parseListPage(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	v, err := parseView(r, store.Article{}, articleIncludes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	articles, err := app.store.Articles.List(ctx, filter, page.fetch())
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	articles, hasMore := pageItems(articles, page)
+
+	var total *int
+	if page.count {
+		n, err := app.store.Articles.Count(ctx, filter)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		total = &n
+	}
+
+	if err := app.setViewerFields(ctx, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.includeArticleResources(ctx, v, viewer, articles); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	items, err := projectAll(v, articles)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	app.listResponse(w, r, items, page, hasMore, total)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+	"golang.org/x/text/language"
+)
+
+const mailTimeout = time.Second * 30
+
+// compared instead of a real password for unknown emails,
+// so they take as long as known ones and do not reveal registered users
+var dummyUser = func() *store.User {
+	user := &store.User{}
+	if err := user.Password.Set(rand.Text()); err != nil {
+		panic(err)
+	}
+	return user
+}()
+
+// failed logins are counted per account and per client ip
+type loginKeys struct {
+	account string
+	ip      string
+}
+
+func newLoginKeys(r *http.Request, email string) loginKeys {
+	return loginKeys{
+		account: "account:" + strings.ToLower(strings.TrimSpace(email)),
+		ip:      "ip:" + clientIP(r),
+	}
+}
+
+// loginReservation is a login attempt counted as failed until it succeeds
+type loginReservation struct {
+	account *store.LoginAttempt
+	ip      *store.LoginAttempt
+}
+
+// reserveLogin counts the attempt before the credentials are checked, so concurrent attempts
+// can not pass the lockout check together. Returns how long the client has to wait when it is not allowed
+func (app *application) reserveLogin(ctx context.Context, keys loginKeys) (*loginReservation, time.Duration, error) {
+	policy := app.config().lockout
+
+	var wait time.Duration
+	allow := func(p auth.LockoutPolicy) func(*store.LoginAttempt) bool {
+		return func(a *store.LoginAttempt) bool {
+			wait = p.Wait(a.Failures, a.LastFailure, a.LockedUntil, time.Now())
+			return wait == 0
+		}
+	}
+
+	account, err := app.store.LoginAttempts.Reserve(ctx, keys.account, policy.account.ResetAfter, allow(policy.account))
+	if err != nil || account == nil {
+		return nil, wait, err
+	}
+	ip, err := app.store.LoginAttempts.Reserve(ctx, keys.ip, policy.ip.ResetAfter, allow(policy.ip))
+	if err != nil || ip == nil {
+		// the attempt is refused before the account is tried
+		if releaseErr := app.store.LoginAttempts.Release(ctx, keys.account); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return nil, wait, err
+	}
+
+	return &loginReservation{account: account, ip: ip}, 0, nil
+}
+
+// releaseLogin takes back the attempt of right credentials, failures are kept until the login completes
+func (app *application) releaseLogin(ctx context.Context, res *loginReservation) error {
+	return errors.Join(
+		app.store.LoginAttempts.Release(ctx, res.account.Key),
+		app.store.LoginAttempts.Release(ctx, res.ip.Key),
+	)
+}
+
+// loginSucceeded forgets the failures of the account, the ip keeps the earlier ones
+func (app *application) loginSucceeded(ctx context.Context, res *loginReservation) error {
+	return errors.Join(
+		app.store.LoginAttempts.Reset(ctx, res.account.Key),
+		app.store.LoginAttempts.Release(ctx, res.ip.Key),
+	)
+}
+
+// loginFailed keeps the reserved failure, user is nil for unknown emails.
+// The owner is notified when the account gets locked.
+func (app *application) loginFailed(r *http.Request, res *loginReservation, user *store.User) error {
+	ctx := r.Context()
+	accountLocked, err := app.lockAfterFailure(ctx, res.account, app.config().lockout.account)
+	if err != nil {
+		return err
+	}
+	if !accountLocked.IsZero() {
+		app.requestLogger(r).Warnw("account locked", "key", res.account.Key, "until", accountLocked)
+		if user != nil {
+			app.notifyLockout(i18n.FromContext(ctx), user, accountLocked)
+		}
+	}
+
+	ipLocked, err := app.lockAfterFailure(ctx, res.ip, app.config().lockout.ip)
+	if err != nil {
+		return err
+	}
+	if !ipLocked.IsZero() {
+		app.requestLogger(r).Warnw("ip locked", "key", res.ip.Key, "until", ipLocked)
+	}
+
+	return nil
+}
+
+// returns lock end when this failure locked the key
+func (app *application) lockAfterFailure(ctx context.Context, attempt *store.LoginAttempt, policy auth.LockoutPolicy) (time.Time, error) {
+	if !policy.ShouldLock(attempt.Failures) {
+		return time.Time{}, nil
+	}
+
+	until := attempt.LastFailure.Add(policy.LockFor)
+	return until, app.store.LoginAttempts.Lock(ctx, attempt.Key, until)
+}
+
+// users have no stored language, the mail follows the language of the login request
+func (app *application) notifyLockout(lang language.Tag, user *store.User, until time.Time) {
+	subject := i18n.T(lang, "mail.lockout.subject")
+	body := i18n.T(lang, "mail.lockout.body", user.Username, until.UTC().Format(time.RFC1123))
+
+	app.background(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+
+		if err := app.mailer().Send(ctx, user.Email, subject, body); err != nil {
+			app.logger.Errorw("lockout notification", "user_id", user.ID, "error", err.Error())
+		}
+	})
+}
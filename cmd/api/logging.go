@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/critma/goblog/internal/logging"
+	"github.com/critma/goblog/internal/store"
+	"github.com/critma/goblog/internal/store/instrument"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.uber.org/zap"
+)
+
+// store calls slower than this are logged
+const slowStoreCall = time.Millisecond * 500
+
+type accessLogKey struct{}
+
+// filled by inner middlewares, the access log is written after them
+type accessLogEntry struct {
+	userID int
+}
+
+// AccessLogMiddleware puts a request logger into the context and writes an access log line
+func (app *application) AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		logger := app.logger.With(
+			"request_id", middleware.GetReqID(r.Context()),
+			"trace_id", traceID(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+		)
+		entry := &accessLogEntry{}
+		ctx := logging.NewContext(r.Context(), logger)
+		ctx = context.WithValue(ctx, accessLogKey{}, entry)
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		fields := []any{
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote_addr", clientIP(r),
+		}
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			fields = append(fields, "route", rctx.RoutePattern())
+		}
+		if entry.userID != 0 {
+			fields = append(fields, "user_id", entry.userID)
+		}
+
+		if status >= http.StatusInternalServerError {
+			logger.Errorw("request", fields...)
+		} else {
+			logger.Infow("request", fields...)
+		}
+	})
+}
+
+// withRequestUser adds the authenticated user to the request logger and the access log
+func (app *application) withRequestUser(ctx context.Context, user *store.User) context.Context {
+	if entry, ok := ctx.Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.userID = user.ID
+	}
+	return logging.With(ctx, app.logger, "user_id", user.ID)
+}
+
+// requestLogger returns the logger of the request with its correlation fields
+func (app *application) requestLogger(r *http.Request) *zap.SugaredLogger {
+	return logging.FromContext(r.Context(), app.logger)
+}
+
+// missing rows and constraint violations are answers for the client, not failures of the store
+func storeCallFailed(err error) bool {
+	var constraintErr *store.ConstraintError
+	return err != nil && !errors.Is(err, store.ErrNotFound) && !errors.As(err, &constraintErr)
+}
+
+// storeLogger is an instrument.Observer logging failed and slow store calls with the request logger
+func storeLogger(fallback *zap.SugaredLogger) instrument.Observer {
+	return func(ctx context.Context, storeName, method string) (context.Context, func(error)) {
+		start := time.Now()
+		return ctx, func(err error) {
+			logger := logging.FromContext(ctx, fallback)
+			duration := time.Since(start)
+
+			switch {
+			case storeCallFailed(err):
+				logger.Warnw("store call failed", "store", storeName, "method", method, "duration", duration, "error", err.Error())
+			case duration > slowStoreCall:
+				logger.Warnw("slow store call", "store", storeName, "method", method, "duration", duration)
+			}
+		}
+	}
+}
@@ -1,11 +1,24 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/critma/goblog/internal/auth"
-	"github.com/critma/goblog/internal/env"
+	"github.com/critma/goblog/internal/ratelimit"
+	"github.com/critma/goblog/internal/store/cache"
+	"github.com/critma/goblog/internal/store/instrument"
 	"github.com/critma/goblog/internal/store/postgres"
+	"github.com/critma/goblog/internal/tracing"
+	"github.com/critma/goblog/internal/webauthn"
 	"github.com/joho/godotenv"
 	"go.uber.org/zap"
 
@@ -15,6 +28,13 @@ import (
 
 // const version = "1.0.0"
 
+const (
+	exitOK    = 0
+	exitError = 1
+	// shutdown deadline was exceeded and requests or workers were cut off
+	exitForced = 2
+)
+
 //	@title			GoBlog API
 //	@version		1.0.0
 //	@description	API server for GoBlog web application.
@@ -32,50 +52,209 @@ import (
 // @in							header
 // @name						Authorization
 func main() {
-	logger := zap.Must(zap.NewProduction()).Sugar()
-	defer logger.Sync()
+	// the file is optional, deployments may pass the environment directly
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "loading .env:", err)
+		os.Exit(exitError)
+	}
 
-	if err := godotenv.Load(); err != nil {
-		logger.Fatal("Error loading .env file", err)
+	config, settings, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitOK)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(exitError)
+	}
+	warnings, err := config.validate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		os.Exit(exitError)
+	}
 
+	logger, logLevel, err := newLogger(config.log)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "logger:", err)
+		os.Exit(exitError)
 	}
-	config := setConfig()
+	effective := map[string]string{}
+	for _, s := range settings.Effective() {
+		effective[s.Key] = s.Value
+	}
+	logger.Infow("config loaded", "mode", config.mode, "file", settings.File(), "settings", effective)
+	for _, w := range warnings {
+		logger.Warnw("weak config", "problem", w)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	db, err := postgres.NewConnection(config.db.addr, config.db.maxOpenConns, config.db.maxIdleConns, config.db.maxIdleTime)
+	db, err := connectDB(ctx, config.db, logger)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if config.db.migrate {
+		applied, err := postgres.Migrate(ctx, db)
+		for _, m := range applied {
+			logger.Infow("schema migrated", "migration", m.Name)
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
+	var storeCache *cache.Cache
+	if config.cache.size > 0 {
+		storeCache = cache.New(config.cache.size, config.cache.ttl)
+	}
+	appMetrics := newAppMetrics(db, storeCache)
+
+	tracer, err := newTracer(config.tracing, logger)
 	if err != nil {
 		logger.Fatal(err)
 	}
-	defer db.Close()
-	store := postgres.NewStorage(db)
+
+	// cache hits are not store calls, so it wraps the instrumented store
+	store := instrument.Wrap(postgres.NewStorage(db), appMetrics.observeStore)
+	store = instrument.Wrap(store, storeLogger(logger))
+	store = instrument.Wrap(store, storeTracer(tracer))
+	if storeCache != nil {
+		store = storeCache.Wrap(store)
+	}
 
 	JWTAuthenticator := auth.NewJWTAuthenticator(
 		config.auth.secret, config.auth.issuer, config.auth.issuer,
 	)
+	if config.auth.keysDir != "" {
+		keys, err := auth.LoadKeysFromDir(config.auth.keysDir)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		// tokens signed by the secret before the switch stay valid until they expire
+		if config.auth.secret != "" {
+			retiredAt := config.auth.secretRetiredAt
+			keys = append(keys, auth.NewRetiredHMACKey([]byte(config.auth.secret), retiredAt))
+			logger.Warnw("auth.secret only verifies tokens issued before auth.secret_retired_at, remove it after auth.token_ttl",
+				"until", retiredAt.Add(config.auth.exp))
+		}
+		JWTAuthenticator = auth.NewJWTAuthenticatorWithKeys(
+			keys, config.auth.exp, config.auth.issuer, config.auth.issuer,
+		)
+	}
+
+	var rateLimiter ratelimit.Store = ratelimit.NewMemoryStore()
+	if config.rateLimit.store == "postgres" {
+		rateLimiter = postgres.NewRateLimitStore(db)
+	}
 
 	app := &application{
-		config:        *config,
+		settings:      settings,
+		configArgs:    os.Args[1:],
 		store:         store,
 		logger:        logger,
+		logLevel:      logLevel,
 		authenticator: JWTAuthenticator,
+		webauthn: &webauthn.Config{
+			RPID:    config.webauthn.rpID,
+			RPName:  config.webauthn.rpName,
+			Origins: config.webauthn.origins,
+		},
+		rateLimiter: rateLimiter,
+		cache:       storeCache,
+		workers:     newWorkerGroup(),
+		db:          db,
+		metrics:     appMetrics,
+		tracer:      tracer,
+	}
+	app.cfg.Store(config)
+
+	app.startWorker("trace exporter", tracer.Run)
+	app.startWorker("config reloader", app.reloadOnSignal)
+	if storeCache != nil {
+		// other instances write to the same database
+		listener := postgres.NewInvalidationListener(config.db.addr, storeCache, logger)
+		app.startWorker("cache listener", listener.Run)
 	}
 
 	mux := app.mount()
-	logger.Fatal(app.run(mux))
+	err = app.run(ctx, mux)
+
+	code := exitOK
+	switch {
+	case errors.Is(err, errForcedShutdown):
+		logger.Errorw("forced shutdown", "error", err.Error())
+		code = exitForced
+	case err != nil:
+		logger.Errorw("server error", "error", err.Error())
+		code = exitError
+	}
+
+	if err := db.Close(); err != nil {
+		logger.Errorw("closing database", "error", err.Error())
+	}
+	// syncing stderr fails on some platforms, there is nowhere to report it anyway
+	_ = logger.Sync()
+
+	os.Exit(code)
 }
 
-func setConfig() *config {
-	return &config{
-		addr: env.GetNonEmptyString("ADDR", ":8080"),
-		db: dbConfig{
-			addr:         env.GetNonEmptyString("DB_ADDR", "postgres://admin:admin@db/blog?sslmode=disable"),
-			maxOpenConns: env.GetInt("DB_MAX_OPEN_CONNS", 30),
-			maxIdleConns: env.GetInt("DB_MAX_IDLE_CONNS", 30),
-			maxIdleTime:  env.GetNonEmptyString("DB_MAX_IDLE_TIME", "15m"),
-		},
-		auth: authConfig{
-			secret: env.GetNonEmptyString("AUTH_SECRET", "secret"),
-			issuer: env.GetNonEmptyString("AUTH_ISSUER", "blog"),
-			exp:    time.Hour * 24,
-		},
+// connectDB retries with exponential backoff, database may start later than the api
+func connectDB(ctx context.Context, cfg dbConfig, logger *zap.SugaredLogger) (*sql.DB, error) {
+	const maxWait = time.Second * 30
+
+	deadline := time.Now().Add(cfg.connectTimeout)
+	wait := time.Second
+	for attempt := 1; ; attempt++ {
+		db, err := postgres.NewConnection(cfg.addr, cfg.maxOpenConns, cfg.maxIdleConns, cfg.maxIdleTime)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+
+		logger.Warnw("database is not available", "attempt", attempt, "retry_in", wait, "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, maxWait)
 	}
 }
+
+// the returned level changes the logger at runtime
+func newLogger(cfg logConfig) (*zap.SugaredLogger, zap.AtomicLevel, error) {
+	level, err := zap.ParseAtomicLevel(cfg.level)
+	if err != nil {
+		return nil, level, err
+	}
+
+	zapConfig := zap.NewProductionConfig()
+	zapConfig.Level = level
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, level, err
+	}
+	return logger.Sugar(), level, nil
+}
+
+func newTracer(cfg tracingConfig, logger *zap.SugaredLogger) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.exporter {
+	case "":
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.otlpEndpoint)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(cfg.file)
+		if err != nil {
+			return nil, err
+		}
+		exporter = fileExporter
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.exporter)
+	}
+
+	return tracing.NewTracer(cfg.serviceName, exporter, func(err error) {
+		logger.Warnw("trace export", "error", err.Error())
+	}), nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/critma/goblog/internal/metrics"
+	"github.com/critma/goblog/internal/store/cache"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+type appMetrics struct {
+	registry *metrics.Registry
+
+	httpRequests  *metrics.Counter
+	httpDuration  *metrics.Histogram
+	storeDuration *metrics.Histogram
+	storeErrors   *metrics.Counter
+
+	usersRegistered *metrics.Counter
+	articlesCreated *metrics.Counter
+	commentsCreated *metrics.Counter
+	articleLikes    *metrics.Counter
+}
+
+// newAppMetrics registers api metrics, storeCache may be nil
+func newAppMetrics(db *sql.DB, storeCache *cache.Cache) *appMetrics {
+	r := metrics.NewRegistry()
+
+	m := &appMetrics{
+		registry: r,
+
+		httpRequests: r.NewCounter("http_requests_total",
+			"HTTP requests by route pattern and status class.", "method", "route", "status"),
+		httpDuration: r.NewHistogram("http_request_duration_seconds",
+			"HTTP request latency by route pattern.", metrics.DefaultBuckets, "method", "route"),
+		storeDuration: r.NewHistogram("store_call_duration_seconds",
+			"Latency of store methods.", metrics.DefaultBuckets, "store", "method"),
+		storeErrors: r.NewCounter("store_call_errors_total",
+			"Failed store calls, not found results excluded.", "store", "method"),
+
+		usersRegistered: r.NewCounter("users_registered_total", "Registered users."),
+		articlesCreated: r.NewCounter("articles_created_total", "Created articles."),
+		commentsCreated: r.NewCounter("comments_created_total", "Created comments."),
+		articleLikes:    r.NewCounter("article_likes_total", "Likes of articles."),
+	}
+
+	dbStat := func(name, help string, kind metrics.Kind, value func(sql.DBStats) float64) {
+		r.NewFunc(name, help, kind, nil, func(emit func(float64, ...string)) {
+			emit(value(db.Stats()))
+		})
+	}
+	dbStat("db_max_open_connections", "Maximum number of open connections to the database.", metrics.KindGauge,
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	dbStat("db_open_connections", "Established connections, in use and idle.", metrics.KindGauge,
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	dbStat("db_in_use_connections", "Connections currently in use.", metrics.KindGauge,
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	dbStat("db_idle_connections", "Idle connections.", metrics.KindGauge,
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	dbStat("db_wait_count_total", "Connections waited for.", metrics.KindCounter,
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	dbStat("db_wait_duration_seconds_total", "Time blocked waiting for a connection.", metrics.KindCounter,
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	dbStat("db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", metrics.KindCounter,
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	dbStat("db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", metrics.KindCounter,
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	dbStat("db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", metrics.KindCounter,
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+
+	if storeCache != nil {
+		cacheStat := func(name, help string, kind metrics.Kind, value func(cache.Stats) float64) {
+			r.NewFunc(name, help, kind, []string{"cache"}, func(emit func(float64, ...string)) {
+				for name, stats := range storeCache.Stats() {
+					emit(value(stats), name)
+				}
+			})
+		}
+		cacheStat("cache_hits_total", "Store cache hits.", metrics.KindCounter,
+			func(s cache.Stats) float64 { return float64(s.Hits) })
+		cacheStat("cache_misses_total", "Store cache misses.", metrics.KindCounter,
+			func(s cache.Stats) float64 { return float64(s.Misses) })
+		cacheStat("cache_evictions_total", "Store cache entries evicted by size.", metrics.KindCounter,
+			func(s cache.Stats) float64 { return float64(s.Evictions) })
+		cacheStat("cache_entries", "Store cache entries.", metrics.KindGauge,
+			func(s cache.Stats) float64 { return float64(s.Size) })
+	}
+
+	return m
+}
+
+// observeStore is an instrument.Observer
+func (m *appMetrics) observeStore(ctx context.Context, storeName, method string) (context.Context, func(error)) {
+	start := time.Now()
+	return ctx, func(err error) {
+		m.storeDuration.Observe(time.Since(start).Seconds(), storeName, method)
+		if storeCallFailed(err) {
+			m.storeErrors.Inc(storeName, method)
+		}
+	}
+}
+
+// MetricsMiddleware counts requests by chi route pattern, so ids in paths do not multiply series
+func (app *application) MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		app.metrics.httpRequests.Inc(r.Method, route, strconv.Itoa(status/100)+"xx")
+		app.metrics.httpDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
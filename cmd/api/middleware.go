@@ -4,67 +4,383 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 
+	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/i18n"
 	"github.com/critma/goblog/internal/store"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// AuthTokenMiddleware authenticates by the bearer token, or by the session cookie of browsers.
+// State-changing requests of cookie sessions must carry the csrf token of the session
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
+	return app.authMiddleware(next, false)
+}
+
+// OptionalAuthMiddleware lets requests without credentials through anonymously,
+// credentials which are sent must still be valid
+func (app *application) OptionalAuthMiddleware(next http.Handler) http.Handler {
+	return app.authMiddleware(next, true)
+}
+
+var errAuthRequired = i18n.Error("auth.required")
+
+// RequireAuthMiddleware rejects anonymous requests of routes behind OptionalAuthMiddleware
+func (app *application) RequireAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			app.unauthorizedErrorResponse(w, r, errors.New("authorization header is missing"))
+		if getUserFromContext(r) == nil {
+			app.unauthorizedErrorResponse(w, r, errAuthRequired)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			app.unauthorizedErrorResponse(w, r, errors.New("authorization header format must be Bearer {token}"))
-			return
-		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-		token := parts[1]
-		jwtToken, err := app.authenticator.ValidateToken(token)
-		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
-			return
-		}
+func (app *application) authMiddleware(next http.Handler, optional bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
 
-		claims, _ := jwtToken.Claims.(jwt.MapClaims)
+		cookie, cookieErr := r.Cookie(sessionCookie)
+		if optional {
+			// responses depend on the viewer
+			w.Header().Add("Vary", "Authorization, Cookie")
+			if r.Header.Get("Authorization") == "" && cookieErr != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
 
-		userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
-		if err != nil {
-			app.unauthorizedErrorResponse(w, r, err)
-			return
+		var (
+			userID  int
+			session *store.Session
+			err     error
+		)
+		if r.Header.Get("Authorization") == "" && cookieErr == nil {
+			session, err = app.authenticateSession(r, cookie.Value)
+			if err != nil {
+				if errors.Is(err, store.ErrNotFound) {
+					// expired or revoked, the browser should forget it
+					app.clearSessionCookies(w)
+					app.unauthorizedErrorResponse(w, r, i18n.Error("auth.session_expired"))
+					return
+				}
+				app.internalServerError(w, r, err)
+				return
+			}
+			if !csrfSafeMethod(r.Method) && !auth.ValidCSRFToken(session.CSRFToken, r.Header.Get(csrfHeader)) {
+				app.forbiddenResponse(w, r, errInvalidCSRFToken)
+				return
+			}
+			userID = session.UserID
+		} else {
+			userID, err = app.bearerUserID(r)
+			if err != nil {
+				app.unauthorizedErrorResponse(w, r, err)
+				return
+			}
 		}
 
-		ctx := r.Context()
-		//TODO: cache user
-		user, err := app.store.Users.GetByID(ctx, int(userID))
+		user, err := app.store.Users.GetByID(ctx, userID)
 		if err != nil {
 			app.unauthorizedErrorResponse(w, r, err)
 			return
 		}
 
 		ctx = context.WithValue(ctx, userCtx, user)
+		if session != nil {
+			ctx = context.WithValue(ctx, sessionCtx, session)
+		}
+		ctx = app.withRequestUser(ctx, user)
 		next.ServeHTTP(w, r.WithContext(ctx))
-
 	})
 }
 
+// user id of the access token in the Authorization header
+func (app *application) bearerUserID(r *http.Request) (int, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return 0, errors.New("authorization header is missing")
+	}
+
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return 0, errors.New("authorization header format must be Bearer {token}")
+	}
+
+	token := parts[1]
+	_, span := app.tracer.Start(r.Context(), "jwt.validate")
+	jwtToken, err := app.authenticator.ValidateToken(token)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return 0, err
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if scope, _ := claims["scope"].(string); scope != "" {
+		return 0, fmt.Errorf("token with scope %q is not an access token", scope)
+	}
+
+	userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int(userID), nil
+}
+
 func (app *application) CheckArticleOwnershipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		article := r.Context().Value(articleCtx).(*store.Article)
 		user := r.Context().Value(userCtx).(*store.User)
 
 		if article.AuthorID != user.ID {
-			app.unauthorizedErrorResponse(w, r, errors.New("you don't have permission to do this"))
+			app.unauthorizedErrorResponse(w, r, i18n.Error("auth.permission_denied"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// blocks users who must use two-factor authentication until they enroll
+func (app *application) TwoFactorEnrolledMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := getUserFromContext(r)
+
+		if app.twoFactorRequired(user) && !user.TwoFactor.Enabled {
+			app.forbiddenResponse(w, r, i18n.Error("twofactor.enrollment_required"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) RequireRoleMiddleware(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getUserFromContext(r)
+
+			if !slices.Contains(roles, user.Role) {
+				app.forbiddenResponse(w, r, i18n.Error("auth.permission_denied"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitMiddleware limits requests of the group by authenticated user,
+// or by client ip when the user is unknown. The limit is read per request, reloads change it
+func (app *application) RateLimitMiddleware(name string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit := app.config().rateLimit.limit(name)
+			if !limit.Enabled() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := name + ":ip:" + clientIP(r)
+			if user := getUserFromContext(r); user != nil {
+				key = name + ":user:" + strconv.Itoa(user.ID)
+			}
+
+			res, err := app.rateLimiter.Take(r.Context(), key, limit)
+			if err != nil {
+				// limiter failure should not take the api down
+				app.requestLogger(r).Errorw("rate limiter error", "key", key, "error", err.Error())
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Requests, int(limit.Per.Seconds())))
+
+			if !res.Allowed {
+				app.rateLimitExceededResponse(w, r, res.RetryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// response headers readable by scripts of other origins
+const corsExposedHeaders = "ETag, Last-Modified, Retry-After, Content-Language, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, RateLimit-Policy, Link"
+
+// CORSMiddleware lets browsers on the allowed origins call the api and answers their preflights
+func (app *application) CORSMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		h := w.Header()
+		h.Add("Vary", "Origin")
+
+		cfg := app.config().cors
+		if origin == "" || !originAllowed(cfg.allowedOrigins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		if cfg.allowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", strings.Join(cfg.allowedMethods, ", "))
+
+			headers := strings.Join(cfg.allowedHeaders, ", ")
+			if slices.Contains(cfg.allowedHeaders, "*") {
+				// browsers ignore "*" on credentialed requests, the requested headers are echoed instead
+				headers = r.Header.Get("Access-Control-Request-Headers")
+			}
+			if headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			}
+			if cfg.maxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.maxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// originAllowed matches exact origins, "*" and "scheme://*.domain" patterns covering subdomains of any depth
+func originAllowed(allowed []string, origin string) bool {
+	for _, pattern := range allowed {
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if !ok || !strings.HasPrefix(origin, scheme+"://") || !strings.HasSuffix(origin, "."+domain) {
+			continue
+		}
+		sub := origin[len(scheme)+len("://") : len(origin)-len(domain)-1]
+		if sub != "" && !strings.ContainsAny(sub, ":/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SecurityHeadersMiddleware hardens browsers against sniffing, framing, referrer leaks and tls downgrades
+func (app *application) SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.config().security
+		h := w.Header()
+
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Content-Security-Policy", withFrameAncestors(cfg.contentSecurityPolicy, cfg.frameAncestors))
+		// for browsers without frame-ancestors support
+		if slices.Equal(cfg.frameAncestors, []string{"'none'"}) {
+			h.Set("X-Frame-Options", "DENY")
+		}
+		if cfg.referrerPolicy != "" {
+			h.Set("Referrer-Policy", cfg.referrerPolicy)
+		}
+
+		// browsers ignore hsts over plain http, it would only pin a development setup
+		if cfg.hstsMaxAge > 0 && overTLS(r) {
+			hsts := "max-age=" + strconv.Itoa(int(cfg.hstsMaxAge.Seconds()))
+			if cfg.hstsIncludeSubdomains {
+				hsts += "; includeSubDomains"
+			}
+			h.Set("Strict-Transport-Security", hsts)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// HTMLPageMiddleware replaces the api policy for pages running their own scripts and styles
+func (app *application) HTMLPageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := app.config().security
+		w.Header().Set("Content-Security-Policy", withFrameAncestors(cfg.htmlContentSecurityPolicy, cfg.frameAncestors))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withFrameAncestors(policy string, ancestors []string) string {
+	directive := "frame-ancestors " + strings.Join(ancestors, " ")
+	policy = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(policy), ";"))
+	if policy == "" {
+		return directive
+	}
+	return policy + "; " + directive
+}
+
+// tls is terminated by the api or by a proxy setting X-Forwarded-Proto,
+// which is trusted like the X-Real-IP of middleware.RealIP
+func overTLS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func (app *application) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(app.config().limits.maxBodyBytes))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RecoverMiddleware answers panics of handlers with a 500 problem,
+// http.ErrAbortHandler is passed on to abort the response
+func (app *application) RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			app.requestLogger(r).Errorw("panic", "panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+			writeProblem(w, newProblem(r, http.StatusInternalServerError, problemInternal, localize(r, "problem.internal")))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LanguageMiddleware negotiates the language of messages from Accept-Language
+func (app *application) LanguageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+		h := w.Header()
+		h.Set("Content-Language", lang.String())
+		h.Add("Vary", "Accept-Language")
+
+		next.ServeHTTP(w, r.WithContext(i18n.NewContext(r.Context(), lang)))
+	})
+}
+
+// remote address without port, middleware.RealIP has already applied proxy headers
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://blog.example.org", "https://*.example.com"}
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "exact", origin: "https://blog.example.org", want: true},
+		{name: "exact with another scheme", origin: "http://blog.example.org"},
+		{name: "exact with a port", origin: "https://blog.example.org:8443"},
+		{name: "exact with a default port", origin: "https://blog.example.org:443"},
+		{name: "subdomain", origin: "https://api.example.com", want: true},
+		{name: "nested subdomain", origin: "https://a.b.example.com", want: true},
+		{name: "bare domain", origin: "https://example.com"},
+		{name: "domain ending the same", origin: "https://evil-example.com"},
+		{name: "domain as a subdomain", origin: "https://example.com.evil.org"},
+		{name: "subdomain with another scheme", origin: "http://api.example.com"},
+		{name: "subdomain with a port", origin: "https://api.example.com:8443"},
+		{name: "port before the domain", origin: "https://evil.org:1.example.com"},
+		{name: "path before the domain", origin: "https://evil.org/.example.com"},
+		{name: "null", origin: "null"},
+		{name: "empty", origin: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := originAllowed(allowed, tt.origin); got != tt.want {
+				t.Errorf("originAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("any", func(t *testing.T) {
+		for _, origin := range []string{"https://evil.org", "null"} {
+			if !originAllowed([]string{"*"}, origin) {
+				t.Errorf("originAllowed(%q) with * = false, want true", origin)
+			}
+		}
+		if originAllowed(nil, "https://blog.example.org") {
+			t.Error("origin allowed by an empty list")
+		}
+	})
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+	"github.com/critma/goblog/internal/webauthn"
+	"github.com/go-chi/chi/v5"
+)
+
+var errUnknownCeremony = i18n.Error("passkey.unknown_ceremony")
+
+// PasskeyCredential is PublicKeyCredential serialized by its toJSON()
+type PasskeyCredential struct {
+	ID                      string          `json:"id"`
+	RawID                   webauthn.Bytes  `json:"rawId" validate:"required"`
+	Type                    string          `json:"type" validate:"eq=public-key"`
+	AuthenticatorAttachment string          `json:"authenticatorAttachment"`
+	ClientExtensionResults  map[string]any  `json:"clientExtensionResults"`
+	Response                PasskeyResponse `json:"response"`
+}
+
+type PasskeyResponse struct {
+	ClientDataJSON webauthn.Bytes `json:"clientDataJSON" validate:"required"`
+
+	// registration
+	AttestationObject  webauthn.Bytes `json:"attestationObject"`
+	Transports         []string       `json:"transports"`
+	PublicKey          webauthn.Bytes `json:"publicKey"`
+	PublicKeyAlgorithm int            `json:"publicKeyAlgorithm"`
+
+	// registration and login
+	AuthenticatorData webauthn.Bytes `json:"authenticatorData"`
+
+	// login
+	Signature  webauthn.Bytes `json:"signature"`
+	UserHandle webauthn.Bytes `json:"userHandle"`
+}
+
+type RegisterPasskeyPayload struct {
+	Name       string            `json:"name" validate:"max=100"`
+	Credential PasskeyCredential `json:"credential"`
+}
+
+type BeginPasskeyLoginPayload struct {
+	Email string `json:"email" validate:"omitempty,email,max=255"`
+}
+
+type PasskeyLoginPayload struct {
+	Credential PasskeyCredential `json:"credential"`
+	// start a cookie session instead of returning a bearer token
+	Session bool `json:"session"`
+}
+
+// @Summary		Start passkey registration
+// @Description	Get options for navigator.credentials.create()
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	webauthn.CreationOptions
+// @Failure		401	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/passkeys/register/begin [post]
+func (app *application) beginPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	ctx := r.Context()
+
+	existing, err := app.store.Passkeys.GetByUser(ctx, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	challenge, err := app.newWebAuthnChallenge(r, store.CeremonyRegister, user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	entity := webauthn.UserEntity{
+		ID:          passkeyUserHandle(user.ID),
+		Name:        user.Email,
+		DisplayName: user.Username,
+	}
+	options := app.webauthn.CreationOptions(challenge, entity, credentialIDs(existing))
+
+	if err := app.jsonResponse(w, http.StatusOK, options); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Finish passkey registration
+// @Description	Verify navigator.credentials.create() result and save the passkey
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Param			passkey	body		RegisterPasskeyPayload	true	"Credential"
+// @Success		201		{object}	store.Passkey
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		409		{object}	Problem
+// @Failure		500		{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/passkeys/register/finish [post]
+func (app *application) finishPasskeyRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	var payload RegisterPasskeyPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	ctx := r.Context()
+	resp := payload.Credential.Response
+
+	ceremony, err := app.consumeWebAuthnChallenge(r, resp.ClientDataJSON, store.CeremonyRegister)
+	if err != nil {
+		app.passkeyError(w, r, err, app.badRequestResponse)
+		return
+	}
+	if ceremony.UserID != user.ID {
+		app.badRequestResponse(w, r, errUnknownCeremony)
+		return
+	}
+
+	cred, err := app.webauthn.VerifyRegistration(ceremony.Challenge, resp.ClientDataJSON, resp.AttestationObject)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	passkey := &store.Passkey{
+		UserID:       user.ID,
+		CredentialID: cred.ID,
+		PublicKey:    cred.PublicKey,
+		Algorithm:    cred.Algorithm,
+		SignCount:    cred.SignCount,
+		Name:         payload.Name,
+	}
+	if err := app.store.Passkeys.Create(ctx, passkey); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusCreated, passkey); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Get passkeys
+// @Description	Get passkeys of the current user
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	ListResponse{items=[]store.Passkey}
+// @Failure		401	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/passkeys [get]
+func (app *application) getPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	passkeys, err := app.store.Passkeys.GetByUser(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	n := len(passkeys)
+	app.listResponse(w, r, passkeys, fixedPage(n), false, &n)
+}
+
+// @Summary		Delete passkey
+// @Description	Delete passkey of the current user
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Param			id	path	int	true	"Passkey ID"
+// @Success		204
+// @Failure		400	{object}	Problem
+// @Failure		401	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/passkeys/{id} [delete]
+func (app *application) deletePasskeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Passkeys.Delete(r.Context(), int(id), user.ID); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Start passkey login
+// @Description	Get options for navigator.credentials.get(), email limits allowed credentials to the user's passkeys
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Param			user	body		BeginPasskeyLoginPayload	false	"User"
+// @Success		200		{object}	webauthn.RequestOptions
+// @Failure		400		{object}	Problem
+// @Failure		500		{object}	Problem
+// @Router			/auth/passkeys/login/begin [post]
+func (app *application) beginPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	// body is optional for discoverable credentials
+	var payload BeginPasskeyLoginPayload
+	if err := readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	var (
+		userID int
+		allow  [][]byte
+	)
+	if payload.Email != "" {
+		user, err := app.store.Users.GetByEmail(ctx, payload.Email)
+		switch {
+		case err == nil:
+			passkeys, err := app.store.Passkeys.GetByUser(ctx, user.ID)
+			if err != nil {
+				app.internalServerError(w, r, err)
+				return
+			}
+			userID, allow = user.ID, credentialIDs(passkeys)
+		case !errors.Is(err, store.ErrNotFound):
+			app.internalServerError(w, r, err)
+			return
+		}
+	}
+
+	challenge, err := app.newWebAuthnChallenge(r, store.CeremonyLogin, userID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, app.webauthn.RequestOptions(challenge, allow)); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Finish passkey login
+// @Description	Verify navigator.credentials.get() result and issue a token. Without user verification (PIN, biometrics) users with two-factor authentication get a challenge for /auth/2fa/verify.
+// @Tags			passkeys
+// @Accept			json
+// @Produce		json
+// @Param			credential	body		PasskeyLoginPayload	true	"Credential"
+// @Success		202			{object}	string				"token, CookieLogin with session or TwoFactorChallenge"
+// @Failure		400			{object}	Problem
+// @Failure		401			{object}	Problem
+// @Failure		429			{object}	Problem
+// @Failure		500			{object}	Problem
+// @Router			/auth/passkeys/login/finish [post]
+func (app *application) finishPasskeyLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload PasskeyLoginPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	cred := payload.Credential
+	resp := cred.Response
+
+	ceremony, err := app.consumeWebAuthnChallenge(r, resp.ClientDataJSON, store.CeremonyLogin)
+	if err != nil {
+		app.passkeyError(w, r, err, app.unauthorizedErrorResponse)
+		return
+	}
+
+	passkey, err := app.store.Passkeys.GetByCredentialID(ctx, cred.RawID)
+	if err != nil {
+		app.passkeyError(w, r, err, app.unauthorizedErrorResponse)
+		return
+	}
+	if ceremony.UserID != 0 && ceremony.UserID != passkey.UserID {
+		app.unauthorizedErrorResponse(w, r, errors.New("passkey belongs to another user"))
+		return
+	}
+	if len(resp.UserHandle) > 0 && !bytes.Equal(resp.UserHandle, passkeyUserHandle(passkey.UserID)) {
+		app.unauthorizedErrorResponse(w, r, errors.New("user handle mismatch"))
+		return
+	}
+
+	user, err := app.store.Users.GetByID(ctx, passkey.UserID)
+	if err != nil {
+		app.passkeyError(w, r, err, app.unauthorizedErrorResponse)
+		return
+	}
+
+	// passkeys of an account share the lockout of its password
+	attempt, wait, err := app.reserveLogin(ctx, newLoginKeys(r, user.Email))
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+
+	assertion, err := app.webauthn.VerifyAssertion(
+		ceremony.Challenge,
+		passkey.PublicKey,
+		passkey.SignCount,
+		resp.ClientDataJSON,
+		resp.AuthenticatorData,
+		resp.Signature,
+	)
+	if err != nil {
+		if err := app.loginFailed(r, attempt, user); err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.Passkeys.UpdateSignCount(ctx, passkey.ID, assertion.SignCount); err != nil {
+		app.internalServerError(w, r, errors.Join(err, app.releaseLogin(ctx, attempt)))
+		return
+	}
+
+	// a passkey unlocked by a PIN or biometrics is two factors by itself,
+	// one checking presence only is like a password
+	if user.TwoFactor.Enabled && !assertion.UserVerified {
+		app.twoFactorChallengeResponse(w, r, user, attempt)
+		return
+	}
+
+	if err := app.loginSucceeded(ctx, attempt); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user, payload.Session)
+}
+
+func (app *application) newWebAuthnChallenge(r *http.Request, kind string, userID int) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.store.Passkeys.CreateChallenge(r.Context(), &store.WebAuthnChallenge{
+		Challenge: challenge,
+		Kind:      kind,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(webauthn.Timeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// finds the ceremony by the challenge signed in clientDataJSON, each challenge works once
+func (app *application) consumeWebAuthnChallenge(r *http.Request, clientDataJSON []byte, kind string) (*store.WebAuthnChallenge, error) {
+	clientData, err := webauthn.ParseClientData(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	ceremony, err := app.store.Passkeys.ConsumeChallenge(r.Context(), clientData.Challenge, kind)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, errUnknownCeremony
+	}
+	return ceremony, err
+}
+
+// client errors go to respond, everything else is internal
+func (app *application) passkeyError(w http.ResponseWriter, r *http.Request, err error, respond func(http.ResponseWriter, *http.Request, error)) {
+	switch {
+	case errors.Is(err, errUnknownCeremony),
+		errors.Is(err, store.ErrNotFound),
+		errors.Is(err, webauthn.ErrInvalidClientData):
+		respond(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+func passkeyUserHandle(userID int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+func credentialIDs(passkeys []*store.Passkey) [][]byte {
+	ids := make([][]byte, len(passkeys))
+	for i, p := range passkeys {
+		ids[i] = p.CredentialID
+	}
+	return ids
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/critma/goblog/internal/i18n"
+)
+
+// problem types, clients branch on them rather than on messages
+const (
+	problemValidation           = "urn:goblog:problem:validation"
+	problemMalformedBody        = "urn:goblog:problem:malformed-body"
+	problemBodyTooLarge         = "urn:goblog:problem:body-too-large"
+	problemBadRequest           = "urn:goblog:problem:bad-request"
+	problemUnauthorized         = "urn:goblog:problem:unauthorized"
+	problemForbidden            = "urn:goblog:problem:forbidden"
+	problemNotFound             = "urn:goblog:problem:not-found"
+	problemMethodNotAllowed     = "urn:goblog:problem:method-not-allowed"
+	problemConflict             = "urn:goblog:problem:conflict"
+	problemUnprocessable        = "urn:goblog:problem:unprocessable"
+	problemPreconditionRequired = "urn:goblog:problem:precondition-required"
+	problemPreconditionFailed   = "urn:goblog:problem:precondition-failed"
+	problemRateLimited          = "urn:goblog:problem:rate-limited"
+	problemLoginLocked          = "urn:goblog:problem:login-locked"
+	problemInternal             = "urn:goblog:problem:internal"
+)
+
+// Problem is an RFC 7807 error response, served as application/problem+json
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// request id, the same as in the logs
+	Instance string       `json:"instance,omitempty"`
+	TraceID  string       `json:"trace_id,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	// current state of the resource on precondition failures
+	Data any `json:"data,omitempty"`
+}
+
+// FieldError names a json field of the request body and why it was rejected
+type FieldError struct {
+	Field string `json:"field"`
+	Code  string `json:"code"`
+	// limit of min, max, len and similar rules
+	Param  string `json:"param,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func newProblem(r *http.Request, status int, typ, detail string) *Problem {
+	title := localize(r, "status."+strconv.Itoa(status))
+	if strings.HasPrefix(title, "status.") {
+		title = http.StatusText(status)
+	}
+
+	return &Problem{
+		Type:     typ,
+		Title:    title,
+		Status:   status,
+		Detail:   detail,
+		Instance: middleware.GetReqID(r.Context()),
+		TraceID:  traceID(r),
+	}
+}
+
+// localize formats a catalog message in the language of the request
+func localize(r *http.Request, key string, args ...any) string {
+	return i18n.T(i18n.FromContext(r.Context()), key, args...)
+}
+
+// errorDetail translates errors meant for clients, other errors are only logged
+// and replaced by the message of fallbackKey, or no detail when it is empty
+func errorDetail(r *http.Request, err error, fallbackKey string) string {
+	var msg *i18n.Message
+	if errors.As(err, &msg) {
+		return msg.Localize(i18n.FromContext(r.Context()))
+	}
+	if fallbackKey == "" {
+		return ""
+	}
+	return localize(r, fallbackKey)
+}
+
+// validation errors name fields as they are sent in json
+func jsonFieldName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+// requestProblem explains why the request body was rejected,
+// nil when err is not a decoding or validation error
+func requestProblem(r *http.Request, err error) *Problem {
+	var (
+		validationErrs validator.ValidationErrors
+		syntaxErr      *json.SyntaxError
+		typeErr        *json.UnmarshalTypeError
+		maxBytesErr    *http.MaxBytesError
+	)
+
+	invalidFields := func(errs ...FieldError) *Problem {
+		p := newProblem(r, http.StatusBadRequest, problemValidation, localize(r, "body.invalid_fields"))
+		p.Errors = errs
+		return p
+	}
+
+	switch {
+	case errors.As(err, &validationErrs):
+		errs := make([]FieldError, len(validationErrs))
+		for i, fe := range validationErrs {
+			errs[i] = validationFieldError(r, fe)
+		}
+		return invalidFields(errs...)
+
+	case errors.As(err, &typeErr):
+		return invalidFields(FieldError{
+			Field:  typeErr.Field,
+			Code:   "invalid_type",
+			Param:  typeErr.Type.String(),
+			Detail: localize(r, "field.invalid_type", localize(r, "type."+jsonTypeName(typeErr.Type))),
+		})
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return invalidFields(FieldError{Field: field, Code: "unknown_field", Detail: localize(r, "field.unknown")})
+
+	case errors.As(err, &syntaxErr):
+		return newProblem(r, http.StatusBadRequest, problemMalformedBody, localize(r, "body.malformed_at", syntaxErr.Offset))
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newProblem(r, http.StatusBadRequest, problemMalformedBody, localize(r, "body.malformed"))
+
+	case errors.Is(err, io.EOF):
+		return newProblem(r, http.StatusBadRequest, problemMalformedBody, localize(r, "body.empty"))
+
+	case errors.As(err, &maxBytesErr):
+		return newProblem(r, http.StatusRequestEntityTooLarge, problemBodyTooLarge, localize(r, "body.too_large", maxBytesErr.Limit))
+	}
+	return nil
+}
+
+// field path without the payload struct name, e.g. "credential.id"
+func validationFieldError(r *http.Request, fe validator.FieldError) FieldError {
+	field := fe.Field()
+	if _, path, ok := strings.Cut(fe.Namespace(), "."); ok {
+		field = path
+	}
+
+	// limits of strings count characters, of collections items, otherwise values
+	unit := "value"
+	switch fe.Kind() {
+	case reflect.String:
+		unit = "chars"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		unit = "items"
+	}
+	sized := unit != "value"
+
+	res := FieldError{Field: field, Code: "invalid", Param: fe.Param()}
+	key := "field.invalid"
+	switch fe.Tag() {
+	case "required", "required_if", "required_unless", "required_with", "required_without":
+		res.Code, res.Param, key = "required", "", "field.required"
+	case "email":
+		res.Code, key = "invalid_email", "field.invalid_email"
+	case "url", "http_url":
+		res.Code, key = "invalid_url", "field.invalid_url"
+	case "oneof":
+		res.Code, key = "invalid_choice", "field.invalid_choice"
+	case "len":
+		res.Code, key = "invalid_length", "field.length_"+unit
+	case "min", "gte":
+		res.Code, key = "too_small", "field.min_"+unit
+		if sized {
+			res.Code = "too_short"
+		}
+	case "max", "lte":
+		res.Code, key = "too_large", "field.max_"+unit
+		if sized {
+			res.Code = "too_long"
+		}
+	case "gt":
+		res.Code, key = "too_small", "field.gt_value"
+	case "lt":
+		res.Code, key = "too_large", "field.lt_value"
+	case "numeric", "number":
+		res.Code, key = "invalid_number", "field.invalid_number"
+	}
+
+	switch {
+	case res.Code == "invalid_choice":
+		res.Detail = localize(r, key, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case key == "field.invalid" || res.Param == "":
+		res.Detail = localize(r, key)
+	default:
+		res.Detail = localize(r, key, res.Param)
+	}
+	return res
+}
+
+// catalog key of the json type of t
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
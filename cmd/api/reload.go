@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/mailer"
+	"github.com/critma/goblog/internal/settings"
+	"go.uber.org/zap/zapcore"
+)
+
+// settings applied by reloadConfig, a trailing dot covers the whole section.
+// Other settings are wired at startup and need a restart
+var reloadableKeys = []string{
+	"log.level",
+	"rate_limit.global",
+	"rate_limit.auth",
+	"rate_limit.comments",
+	"cors.",
+	"security.",
+	"limits.max_body_bytes",
+	"lockout.",
+	"mail.",
+	"features.",
+	"auth.two_factor_roles",
+}
+
+func reloadable(key string) bool {
+	for _, k := range reloadableKeys {
+		if key == k || strings.HasSuffix(k, ".") && strings.HasPrefix(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadConfig loads the configuration again with the startup arguments and swaps it.
+// Nothing is applied when the new configuration is invalid or changes non-reloadable settings.
+// Environment variables of a running process do not change, so reloads pick up the config file
+func (app *application) reloadConfig() ([]settings.Change, error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	next, nextSettings, err := loadConfig(app.configArgs)
+	if err != nil {
+		return nil, reloadFailed(err)
+	}
+	warnings, err := next.validate()
+	if err != nil {
+		return nil, reloadFailed(err)
+	}
+
+	changes := settings.Diff(app.settings, nextSettings)
+	var rejected []string
+	for _, c := range changes {
+		if !reloadable(c.Key) {
+			rejected = append(rejected, c.Key)
+		}
+	}
+	if len(rejected) > 0 {
+		return nil, i18n.Error("config.restart_required", strings.Join(rejected, ", "))
+	}
+
+	level, _ := zapcore.ParseLevel(next.log.level)
+	app.logLevel.SetLevel(level)
+	app.cfg.Store(next)
+	app.settings = nextSettings
+
+	for _, c := range changes {
+		app.logger.Infow("config changed", "key", c.Key, "old", c.Old, "new", c.New)
+	}
+	for _, w := range warnings {
+		app.logger.Warnw("weak config", "problem", w)
+	}
+	return changes, nil
+}
+
+// reloadFailed keeps the reason in the problem detail, all validation problems on one line
+func reloadFailed(err error) error {
+	return i18n.Error("config.reload_failed", strings.ReplaceAll(err.Error(), "\n", "; "))
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP
+func (app *application) reloadOnSignal(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			changes, err := app.reloadConfig()
+			if err != nil {
+				app.logger.Errorw("config reload rejected", "error", err.Error())
+				continue
+			}
+			app.logger.Infow("config reloaded", "changes", len(changes))
+		}
+	}
+}
+
+type ConfigReload struct {
+	Changes []settings.Change `json:"changes"`
+}
+
+// @Summary		Reload configuration
+// @Description	Reads the config file again and applies reloadable settings, the same as SIGHUP
+// @Tags			admin
+// @Produce		json
+// @Success		200	{object}	ConfigReload
+// @Failure		403	{object}	Problem
+// @Failure		409	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/admin/config/reload [post]
+func (app *application) reloadConfigHandler(w http.ResponseWriter, r *http.Request) {
+	changes, err := app.reloadConfig()
+	if err != nil {
+		// invalid or non-reloadable configuration on disk, the running one is kept
+		app.conflictResponse(w, r, err)
+		return
+	}
+
+	app.requestLogger(r).Infow("config reloaded", "changes", len(changes))
+	if err := app.jsonResponse(w, http.StatusOK, ConfigReload{Changes: changes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// mailer follows the current mail settings
+func (app *application) mailer() mailer.Mailer {
+	cfg := app.config().mail
+	if cfg.smtpHost == "" {
+		return mailer.NewLogMailer(app.logger)
+	}
+	return mailer.NewSMTPMailer(cfg.smtpHost, cfg.smtpPort, cfg.smtpUsername, cfg.smtpPassword, cfg.from)
+}
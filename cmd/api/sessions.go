@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	sessionCookie = "goblog_session"
+	// readable by scripts of the site, which send it back in csrfHeader
+	csrfCookie = "goblog_csrf"
+	csrfHeader = "X-CSRF-Token"
+
+	// last seen is written at most this often instead of on every request
+	sessionTouchInterval = time.Minute
+	maxUserAgentLength   = 255
+)
+
+var sameSiteModes = map[string]http.SameSite{
+	"lax":    http.SameSiteLaxMode,
+	"strict": http.SameSiteStrictMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+var errInvalidCSRFToken = i18n.Error("auth.csrf_invalid")
+
+type sessionKey string
+
+const sessionCtx sessionKey = "session"
+
+// CookieLogin answers logins with "session": true, the session token itself is only in an HttpOnly cookie
+type CookieLogin struct {
+	// send it in the X-CSRF-Token header of state-changing requests, it is also in the goblog_csrf cookie
+	CSRFToken string    `json:"csrf_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type SessionResponse struct {
+	*store.Session
+	// the session of this request
+	Current bool `json:"current"`
+}
+
+// completeLogin answers a successful login with a bearer token, or starts a cookie session when asked to
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User, cookie bool) {
+	if !cookie {
+		token, err := app.issueAuthToken(user)
+		if err != nil {
+			app.internalServerError(w, r, err)
+			return
+		}
+		if err := app.jsonResponse(w, http.StatusAccepted, token); err != nil {
+			app.internalServerError(w, r, err)
+		}
+		return
+	}
+
+	token, hash := auth.NewSessionToken()
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	session := &store.Session{
+		UserID:    user.ID,
+		TokenHash: hash,
+		CSRFToken: auth.NewCSRFToken(),
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+		ExpiresAt: time.Now().Add(app.config().session.ttl),
+	}
+	if err := app.store.Sessions.Create(r.Context(), session); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.setSessionCookies(w, token, session)
+	resp := CookieLogin{CSRFToken: session.CSRFToken, ExpiresAt: session.ExpiresAt}
+	if err := app.jsonResponse(w, http.StatusAccepted, resp); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) setSessionCookies(w http.ResponseWriter, token string, session *store.Session) {
+	cfg := app.config().session
+	cookie := func(name, value string, httpOnly bool) *http.Cookie {
+		return &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     "/",
+			Expires:  session.ExpiresAt,
+			HttpOnly: httpOnly,
+			Secure:   cfg.cookieSecure,
+			SameSite: sameSiteModes[cfg.sameSite],
+		}
+	}
+
+	http.SetCookie(w, cookie(sessionCookie, token, true))
+	http.SetCookie(w, cookie(csrfCookie, session.CSRFToken, false))
+}
+
+func (app *application) clearSessionCookies(w http.ResponseWriter) {
+	cfg := app.config().session
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookie,
+			Secure:   cfg.cookieSecure,
+			SameSite: sameSiteModes[cfg.sameSite],
+		})
+	}
+}
+
+// authenticateSession finds the session of a cookie, ErrNotFound when it is unknown or expired
+func (app *application) authenticateSession(r *http.Request, token string) (*store.Session, error) {
+	ctx := r.Context()
+	session, err := app.store.Sessions.GetByTokenHash(ctx, auth.HashSessionToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	if time.Since(session.LastSeenAt) > sessionTouchInterval {
+		// only the session list shows it, a failed write does not fail the request
+		if err := app.store.Sessions.Touch(ctx, session.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.requestLogger(r).Warnw("session touch", "session_id", session.ID, "error", err.Error())
+		}
+	}
+	return session, nil
+}
+
+// requests which can not change state are not checked for csrf
+func csrfSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// nil for bearer tokens
+func getSessionFromContext(r *http.Request) *store.Session {
+	session, _ := r.Context().Value(sessionCtx).(*store.Session)
+	return session
+}
+
+// @Summary		Get sessions
+// @Description	Get cookie sessions of the current user, one per browser
+// @Tags			auth
+// @Produce		json
+// @Success		200	{object}	ListResponse{items=[]SessionResponse}
+// @Failure		401	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/sessions [get]
+func (app *application) getSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	current := getSessionFromContext(r)
+
+	sessions, err := app.store.Sessions.GetByUser(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	resp := make([]SessionResponse, len(sessions))
+	for i, s := range sessions {
+		resp[i] = SessionResponse{Session: s, Current: current != nil && current.ID == s.ID}
+	}
+	n := len(resp)
+	app.listResponse(w, r, resp, fixedPage(n), false, &n)
+}
+
+// @Summary		Revoke session
+// @Description	Log out a device of the current user
+// @Tags			auth
+// @Param			id	path	int	true	"Session ID"
+// @Success		204
+// @Failure		400	{object}	Problem
+// @Failure		401	{object}	Problem
+// @Failure		403	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/sessions/{id} [delete]
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+
+	if err := app.store.Sessions.Delete(r.Context(), int(id), user.ID); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	if current := getSessionFromContext(r); current != nil && current.ID == int(id) {
+		app.clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Revoke other sessions
+// @Description	Log out every other device of the current user, all of them for bearer tokens
+// @Tags			auth
+// @Success		204
+// @Failure		401	{object}	Problem
+// @Failure		403	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/sessions [delete]
+func (app *application) deleteOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	keepID := 0
+	if current := getSessionFromContext(r); current != nil {
+		keepID = current.ID
+	}
+
+	if err := app.store.Sessions.DeleteOthers(r.Context(), user.ID, keepID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Logout
+// @Description	End the cookie session of this browser, bearer tokens stay valid until they expire
+// @Tags			auth
+// @Success		204
+// @Failure		401	{object}	Problem
+// @Failure		403	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/logout [post]
+func (app *application) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+
+	if current := getSessionFromContext(r); current != nil {
+		err := app.store.Sessions.Delete(r.Context(), current.ID, user.ID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			app.internalServerError(w, r, err)
+			return
+		}
+		app.clearSessionCookies(w)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
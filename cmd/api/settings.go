@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/ratelimit"
+	"github.com/critma/goblog/internal/settings"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	modeDevelopment = "development"
+	modeProduction  = "production"
+
+	// hmac keys shorter than the sha256 output are guessable offline from any issued token
+	minSecretLength = 32
+
+	defaultDBAddr = "postgres://admin:admin@db/blog?sslmode=disable"
+)
+
+// loadConfig reads defaults, then the yaml file, environment variables and flags, later ones win
+func loadConfig(args []string) (*config, *settings.Set, error) {
+	cfg := &config{
+		lockout: lockoutConfig{
+			account: auth.LockoutPolicy{
+				FreeAttempts: 3,
+				BaseDelay:    time.Second,
+				MaxDelay:     time.Minute,
+				ResetAfter:   time.Hour,
+			},
+			ip: auth.LockoutPolicy{
+				FreeAttempts: 20,
+				BaseDelay:    time.Second,
+				MaxDelay:     time.Minute,
+				ResetAfter:   time.Hour,
+			},
+		},
+	}
+
+	s := settings.New("api")
+	s.String(&cfg.mode, "mode", "APP_ENV", modeDevelopment, "development or production")
+	s.String(&cfg.log.level, "log.level", "LOG_LEVEL", "info", "debug, info, warn or error")
+
+	s.String(&cfg.server.addr, "server.addr", "ADDR", ":8080", "listen address")
+	s.String(&cfg.server.metricsAddr, "server.metrics_addr", "METRICS_ADDR", ":9090", "internal listen address of /metrics, empty disables it")
+	s.Duration(&cfg.server.readTimeout, "server.read_timeout", "SERVER_READ_TIMEOUT", time.Second*15, "max time to read a request")
+	s.Duration(&cfg.server.readHeaderTimeout, "server.read_header_timeout", "SERVER_READ_HEADER_TIMEOUT", time.Second*5, "max time to read request headers")
+	s.Duration(&cfg.server.writeTimeout, "server.write_timeout", "SERVER_WRITE_TIMEOUT", time.Second*60, "max time to write a response")
+	s.Duration(&cfg.server.idleTimeout, "server.idle_timeout", "SERVER_IDLE_TIMEOUT", time.Minute, "keep-alive timeout")
+	s.Duration(&cfg.server.handlerTimeout, "server.handler_timeout", "SERVER_HANDLER_TIMEOUT", time.Second*60, "max time of a handler")
+	s.Duration(&cfg.server.shutdownTimeout, "server.shutdown_timeout", "SHUTDOWN_TIMEOUT", time.Second*30, "max time to drain requests and workers")
+	s.Duration(&cfg.server.shutdownDelay, "server.shutdown_delay", "SHUTDOWN_DELAY", 0, "pause between failing readiness and closing the listener")
+
+	s.URL(&cfg.db.addr, "db.addr", "DB_ADDR", defaultDBAddr, "postgres connection url")
+	s.Int(&cfg.db.maxOpenConns, "db.max_open_conns", "DB_MAX_OPEN_CONNS", 30, "max open connections")
+	s.Int(&cfg.db.maxIdleConns, "db.max_idle_conns", "DB_MAX_IDLE_CONNS", 30, "max idle connections")
+	s.Duration(&cfg.db.maxIdleTime, "db.max_idle_time", "DB_MAX_IDLE_TIME", time.Minute*15, "idle connections are closed after this")
+	s.Duration(&cfg.db.connectTimeout, "db.connect_timeout", "DB_CONNECT_TIMEOUT", time.Minute*2, "how long startup retries to connect")
+	s.Bool(&cfg.db.migrate, "db.migrate", "DB_MIGRATE", true, "apply pending schema migrations at startup")
+
+	s.Secret(&cfg.auth.secret, "auth.secret", "AUTH_SECRET", "hmac key for tokens, at least 32 bytes")
+	s.String(&cfg.auth.keysDir, "auth.keys_dir", "AUTH_KEYS_DIR", "", "directory with signing keys, replaces auth.secret")
+	s.Time(&cfg.auth.secretRetiredAt, "auth.secret_retired_at", "AUTH_SECRET_RETIRED_AT", "switch from auth.secret to auth.keys_dir (RFC 3339), the secret verifies older tokens for auth.token_ttl")
+	s.String(&cfg.auth.issuer, "auth.issuer", "AUTH_ISSUER", "blog", "token issuer and audience")
+	s.Duration(&cfg.auth.exp, "auth.token_ttl", "AUTH_TOKEN_TTL", time.Hour*24, "lifetime of access tokens")
+	s.Duration(&cfg.auth.challengeExp, "auth.challenge_ttl", "AUTH_CHALLENGE_TTL", time.Minute*5, "lifetime of two-factor challenge tokens")
+	s.Strings(&cfg.auth.twoFactorRoles, "auth.two_factor_roles", "AUTH_2FA_ROLES", nil, "roles which must use two-factor authentication")
+	s.Secret(&cfg.auth.totpKey, "auth.totp_key", "AUTH_TOTP_KEY", "base64 32 byte key encrypting TOTP secrets in the database")
+
+	s.Duration(&cfg.session.ttl, "session.ttl", "SESSION_TTL", time.Hour*24*7, "lifetime of cookie sessions")
+	s.Bool(&cfg.session.cookieSecure, "session.cookie_secure", "SESSION_COOKIE_SECURE", true, "session cookies are sent only over https")
+	s.String(&cfg.session.sameSite, "session.same_site", "SESSION_SAME_SITE", "lax", "SameSite of session cookies: lax, strict or none")
+
+	s.String(&cfg.webauthn.rpID, "webauthn.rp_id", "WEBAUTHN_RP_ID", "localhost", "relying party id")
+	s.String(&cfg.webauthn.rpName, "webauthn.rp_name", "WEBAUTHN_RP_NAME", "GoBlog", "relying party name")
+	s.Strings(&cfg.webauthn.origins, "webauthn.origins", "WEBAUTHN_ORIGINS", []string{"http://localhost:8080"}, "origins of passkey ceremonies")
+
+	s.Strings(&cfg.cors.allowedOrigins, "cors.allowed_origins", "CORS_ALLOWED_ORIGINS", nil, "browser origins allowed to call the api, https://*.example.com for subdomains")
+	s.Strings(&cfg.cors.allowedMethods, "cors.allowed_methods", "CORS_ALLOWED_METHODS",
+		[]string{"GET", "POST", "PUT", "PATCH", "DELETE"}, "methods allowed to cross-origin requests")
+	s.Strings(&cfg.cors.allowedHeaders, "cors.allowed_headers", "CORS_ALLOWED_HEADERS",
+		[]string{"Authorization", "Content-Type", "Accept-Language", "If-Match", "If-None-Match", csrfHeader}, "request headers allowed to cross-origin requests, * allows any")
+	s.Bool(&cfg.cors.allowCredentials, "cors.allow_credentials", "CORS_ALLOW_CREDENTIALS", false, "cross-origin requests may send cookies")
+	s.Duration(&cfg.cors.maxAge, "cors.max_age", "CORS_MAX_AGE", time.Minute*10, "how long browsers cache preflights")
+
+	s.Duration(&cfg.security.hstsMaxAge, "security.hsts_max_age", "HSTS_MAX_AGE", time.Hour*24*365, "Strict-Transport-Security max-age over tls, 0 disables it")
+	s.Bool(&cfg.security.hstsIncludeSubdomains, "security.hsts_include_subdomains", "HSTS_INCLUDE_SUBDOMAINS", false, "hsts covers subdomains")
+	s.String(&cfg.security.contentSecurityPolicy, "security.content_security_policy", "CONTENT_SECURITY_POLICY",
+		"default-src 'none'", "Content-Security-Policy of api responses")
+	s.String(&cfg.security.htmlContentSecurityPolicy, "security.html_content_security_policy", "HTML_CONTENT_SECURITY_POLICY",
+		"default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; img-src 'self' data:", "Content-Security-Policy of html pages")
+	s.Strings(&cfg.security.frameAncestors, "security.frame_ancestors", "FRAME_ANCESTORS", []string{"'none'"}, "origins allowed to embed the pages in frames")
+	s.String(&cfg.security.referrerPolicy, "security.referrer_policy", "REFERRER_POLICY", "no-referrer", "Referrer-Policy")
+	s.Bool(&cfg.features.registration, "features.registration", "FEATURE_REGISTRATION", true, "sign-ups are allowed")
+	s.Int(&cfg.limits.maxBodyBytes, "limits.max_body_bytes", "MAX_BODY_BYTES", 1_100_000, "max size of json request bodies")
+
+	s.String(&cfg.rateLimit.store, "rate_limit.store", "RATE_LIMIT_STORE", "memory", "memory or postgres")
+	s.Var(newLimitValue(&cfg.rateLimit.global, "300/m"), "rate_limit.global", "RATE_LIMIT_GLOBAL", "requests per ip to the whole api")
+	s.Var(newLimitValue(&cfg.rateLimit.auth, "10/m"), "rate_limit.auth", "RATE_LIMIT_AUTH", "logins and registrations per ip")
+	s.Var(newLimitValue(&cfg.rateLimit.comments, "5/m"), "rate_limit.comments", "RATE_LIMIT_COMMENTS", "comments per user")
+
+	s.Int(&cfg.lockout.account.LockAfter, "lockout.account.lock_after", "LOGIN_LOCK_AFTER", 10, "failed logins of an email before locking it")
+	s.Duration(&cfg.lockout.account.LockFor, "lockout.account.lock_for", "LOGIN_LOCK_DURATION", time.Minute*15, "account lock duration")
+	s.Int(&cfg.lockout.ip.LockAfter, "lockout.ip.lock_after", "LOGIN_IP_LOCK_AFTER", 100, "failed logins from an ip before locking it")
+	s.Duration(&cfg.lockout.ip.LockFor, "lockout.ip.lock_for", "LOGIN_IP_LOCK_DURATION", time.Minute*15, "ip lock duration")
+
+	s.String(&cfg.mail.smtpHost, "mail.smtp_host", "SMTP_HOST", "", "smtp server, mails are only logged when empty")
+	s.Int(&cfg.mail.smtpPort, "mail.smtp_port", "SMTP_PORT", 587, "smtp port")
+	s.String(&cfg.mail.smtpUsername, "mail.smtp_username", "SMTP_USERNAME", "", "smtp user")
+	s.Secret(&cfg.mail.smtpPassword, "mail.smtp_password", "SMTP_PASSWORD", "smtp password")
+	s.String(&cfg.mail.from, "mail.from", "MAIL_FROM", "GoBlog <no-reply@goblog.local>", "sender address")
+
+	s.Int(&cfg.cache.size, "cache.size", "CACHE_SIZE", 1000, "max cached entries per entity, 0 disables the cache")
+	s.Duration(&cfg.cache.ttl, "cache.ttl", "CACHE_TTL", time.Minute, "lifetime of cached entries")
+
+	s.String(&cfg.httpCache.article, "http_cache.article", "CACHE_CONTROL_ARTICLE", "private, no-cache", "Cache-Control of an article")
+	s.String(&cfg.httpCache.comments, "http_cache.comments", "CACHE_CONTROL_COMMENTS", "private, no-cache", "Cache-Control of comments")
+	s.String(&cfg.httpCache.users, "http_cache.users", "CACHE_CONTROL_USERS", "private, no-cache", "Cache-Control of users, the body has the email")
+	s.String(&cfg.httpCache.feed, "http_cache.feed", "CACHE_CONTROL_FEED", "public, max-age=30", "Cache-Control of latest articles")
+	s.String(&cfg.httpCache.authorArticles, "http_cache.author_articles", "CACHE_CONTROL_AUTHOR_ARTICLES", "private, no-cache", "Cache-Control of articles of an author")
+	s.String(&cfg.httpCache.articleList, "http_cache.article_list", "CACHE_CONTROL_ARTICLE_LIST", "private, no-cache", "Cache-Control of article listings")
+
+	s.String(&cfg.tracing.exporter, "tracing.exporter", "TRACING_EXPORTER", "", "otlp, file or empty")
+	s.String(&cfg.tracing.otlpEndpoint, "tracing.otlp_endpoint", "TRACING_OTLP_ENDPOINT", "http://localhost:4318", "otlp/http collector")
+	s.String(&cfg.tracing.file, "tracing.file", "TRACING_FILE", "traces.jsonl", "file of the file exporter")
+	s.String(&cfg.tracing.serviceName, "tracing.service_name", "TRACING_SERVICE_NAME", "goblog-api", "service name of spans")
+
+	if err := s.Load(args); err != nil {
+		return nil, nil, err
+	}
+	return cfg, s, nil
+}
+
+// validate returns all problems at once, warnings are weak settings allowed in development
+func (c *config) validate() (warnings []string, err error) {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	production := c.mode == modeProduction
+	// weak settings fail in production and are reported otherwise
+	weak := func(ok bool, format string, args ...any) {
+		switch {
+		case ok:
+		case production:
+			errs = append(errs, fmt.Errorf(format, args...))
+		default:
+			warnings = append(warnings, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.mode == modeDevelopment || production, "mode: must be %q or %q", modeDevelopment, modeProduction)
+	_, levelErr := zapcore.ParseLevel(c.log.level)
+	check(levelErr == nil, "log.level: unknown level %q", c.log.level)
+
+	check(c.server.addr != "", "server.addr: required")
+	check(c.server.metricsAddr != c.server.addr, "server.metrics_addr: must differ from server.addr")
+	check(c.server.readTimeout > 0, "server.read_timeout: must be positive")
+	check(c.server.readHeaderTimeout > 0, "server.read_header_timeout: must be positive")
+	check(c.server.writeTimeout > 0, "server.write_timeout: must be positive")
+	check(c.server.idleTimeout > 0, "server.idle_timeout: must be positive")
+	check(c.server.handlerTimeout > 0 && c.server.handlerTimeout <= c.server.writeTimeout,
+		"server.handler_timeout: must be positive and not exceed server.write_timeout")
+	check(c.server.shutdownTimeout > 0, "server.shutdown_timeout: must be positive")
+	check(c.server.shutdownDelay >= 0, "server.shutdown_delay: must not be negative")
+
+	check(c.db.addr != "", "db.addr: required")
+	check(c.db.maxOpenConns > 0, "db.max_open_conns: must be positive")
+	check(c.db.maxIdleConns >= 0, "db.max_idle_conns: must not be negative")
+	check(c.db.maxIdleTime > 0, "db.max_idle_time: must be positive")
+	check(c.db.connectTimeout > 0, "db.connect_timeout: must be positive")
+	weak(c.db.addr != defaultDBAddr, "db.addr: uses the development credentials")
+
+	if c.auth.keysDir == "" {
+		check(c.auth.secret != "", "auth.secret: required when auth.keys_dir is empty")
+		weak(c.auth.secret == "" || len(c.auth.secret) >= minSecretLength,
+			"auth.secret: shorter than %d bytes", minSecretLength)
+	} else if c.auth.secret != "" {
+		check(!c.auth.secretRetiredAt.IsZero(), "auth.secret_retired_at: required when auth.secret is kept with auth.keys_dir")
+		weak(c.auth.secretRetiredAt.IsZero() || time.Now().Before(c.auth.secretRetiredAt.Add(c.auth.exp)),
+			"auth.secret: tokens signed by it expired after auth.secret_retired_at, remove it")
+	}
+	check(c.auth.issuer != "", "auth.issuer: required")
+	check(c.auth.exp > 0, "auth.token_ttl: must be positive")
+	check(c.auth.challengeExp > 0, "auth.challenge_ttl: must be positive")
+	_, totpKeyErr := auth.NewSecretBox(c.auth.totpKey)
+	check(totpKeyErr == nil, "auth.totp_key: %v", totpKeyErr)
+	weak(c.auth.totpKey != "", "auth.totp_key: TOTP secrets are stored unencrypted")
+
+	check(c.session.ttl > 0, "session.ttl: must be positive")
+	_, sameSiteOK := sameSiteModes[c.session.sameSite]
+	check(sameSiteOK, "session.same_site: must be lax, strict or none")
+	// browsers drop SameSite=None cookies without Secure
+	check(c.session.sameSite != "none" || c.session.cookieSecure, "session.same_site: none requires session.cookie_secure")
+	weak(c.session.cookieSecure, "session.cookie_secure: session cookies are sent over plain http")
+
+	check(c.webauthn.rpID != "", "webauthn.rp_id: required")
+	check(len(c.webauthn.origins) > 0, "webauthn.origins: required")
+	for _, origin := range c.webauthn.origins {
+		check(validOrigin(origin), "webauthn.origins: invalid origin %q", origin)
+	}
+	for _, origin := range c.cors.allowedOrigins {
+		check(origin == "*" || validOriginPattern(origin), "cors.allowed_origins: invalid origin %q", origin)
+	}
+	weak(!slices.Contains(c.cors.allowedOrigins, "*"), "cors.allowed_origins: allows any origin")
+	// browsers reject credentialed responses allowing any origin
+	check(!c.cors.allowCredentials || !slices.Contains(c.cors.allowedOrigins, "*"),
+		"cors.allow_credentials: can not be used with * in cors.allowed_origins")
+	check(len(c.cors.allowedMethods) > 0, "cors.allowed_methods: required")
+	check(c.cors.maxAge >= 0, "cors.max_age: must not be negative")
+
+	check(c.security.hstsMaxAge >= 0, "security.hsts_max_age: must not be negative")
+	check(len(c.security.frameAncestors) > 0, "security.frame_ancestors: required, 'none' forbids framing")
+	for _, ancestor := range c.security.frameAncestors {
+		check(ancestor == "'none'" || ancestor == "'self'" || validOriginPattern(ancestor),
+			"security.frame_ancestors: invalid source %q", ancestor)
+	}
+	check(c.security.referrerPolicy == "" || slices.Contains(referrerPolicies, c.security.referrerPolicy),
+		"security.referrer_policy: unknown policy %q", c.security.referrerPolicy)
+
+	check(c.limits.maxBodyBytes > 0, "limits.max_body_bytes: must be positive")
+	check(c.rateLimit.store == "memory" || c.rateLimit.store == "postgres", "rate_limit.store: must be memory or postgres")
+	check(c.lockout.account.LockAfter >= 0, "lockout.account.lock_after: must not be negative")
+	check(c.lockout.account.LockFor > 0, "lockout.account.lock_for: must be positive")
+	check(c.lockout.ip.LockAfter >= 0, "lockout.ip.lock_after: must not be negative")
+	check(c.lockout.ip.LockFor > 0, "lockout.ip.lock_for: must be positive")
+
+	check(c.mail.smtpPort > 0 && c.mail.smtpPort < 1<<16, "mail.smtp_port: invalid port %d", c.mail.smtpPort)
+	_, fromErr := mail.ParseAddress(c.mail.from)
+	check(fromErr == nil, "mail.from: invalid address %q", c.mail.from)
+
+	check(c.cache.size >= 0, "cache.size: must not be negative")
+	check(c.cache.ttl > 0, "cache.ttl: must be positive")
+
+	check(slices.Contains([]string{"", "otlp", "file"}, c.tracing.exporter), "tracing.exporter: must be otlp, file or empty")
+	check(c.tracing.serviceName != "", "tracing.service_name: required")
+
+	return warnings, errors.Join(errs...)
+}
+
+// scheme and host without path, as browsers send in the Origin header
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Scheme != "" && u.Host != "" && u.Path == "" && u.RawQuery == ""
+}
+
+// an origin or "scheme://*.domain" matching its subdomains
+func validOriginPattern(origin string) bool {
+	scheme, host, ok := strings.Cut(origin, "://*.")
+	if !ok {
+		return validOrigin(origin) && !strings.Contains(origin, "*")
+	}
+	return validOrigin(scheme+"://"+host) && strings.Contains(host, ".") && !strings.Contains(host, "*")
+}
+
+var referrerPolicies = []string{
+	"no-referrer", "no-referrer-when-downgrade", "origin", "origin-when-cross-origin",
+	"same-origin", "strict-origin", "strict-origin-when-cross-origin", "unsafe-url",
+}
+
+type limitValue ratelimit.Limit
+
+func newLimitValue(p *ratelimit.Limit, def string) *limitValue {
+	*p, _ = ratelimit.ParseLimit(def)
+	return (*limitValue)(p)
+}
+
+func (v *limitValue) Set(s string) error {
+	limit, err := ratelimit.ParseLimit(s)
+	if err != nil {
+		return err
+	}
+	*v = limitValue(limit)
+	return nil
+}
+
+func (v *limitValue) String() string {
+	if !ratelimit.Limit(*v).Enabled() {
+		return "0"
+	}
+	return ratelimit.Limit(*v).String()
+}
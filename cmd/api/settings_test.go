@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	cfg, _, err := loadConfig(args)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	return cfg
+}
+
+func TestValidateWeakSettings(t *testing.T) {
+	strong := []string{
+		"-auth.secret", strings.Repeat("s", minSecretLength),
+		"-db.addr", "postgres://blog:strong@db/blog",
+		"-auth.totp_key", base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "short secret", args: []string{"-auth.secret", "short"}, want: "auth.secret: shorter than"},
+		{name: "development database", args: []string{"-db.addr", defaultDBAddr}, want: "db.addr: uses the development credentials"},
+		{name: "insecure cookies", args: []string{"-session.cookie_secure=false"}, want: "session.cookie_secure"},
+		{name: "unencrypted totp secrets", args: []string{"-auth.totp_key", ""}, want: "auth.totp_key: TOTP secrets are stored unencrypted"},
+		{name: "any cors origin", args: []string{"-cors.allowed_origins", "*"}, want: "cors.allowed_origins: allows any origin"},
+		{
+			name: "expired retired secret",
+			args: []string{"-auth.keys_dir", "keys", "-auth.secret_retired_at", "2020-01-01T00:00:00Z"},
+			want: "auth.secret: tokens signed by it expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append(append([]string{}, strong...), tt.args...)
+
+			warnings, err := testConfig(t, append(args, "-mode", modeDevelopment)...).validate()
+			if err != nil {
+				t.Fatalf("development: validate error = %v, want a warning only", err)
+			}
+			if !strings.Contains(strings.Join(warnings, "\n"), tt.want) {
+				t.Errorf("development: warnings = %q, want %q", warnings, tt.want)
+			}
+
+			_, err = testConfig(t, append(args, "-mode", modeProduction)...).validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("production: validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("strong", func(t *testing.T) {
+		warnings, err := testConfig(t, append(strong, "-mode", modeProduction)...).validate()
+		if err != nil || len(warnings) > 0 {
+			t.Errorf("validate = %q, %v, want no problems", warnings, err)
+		}
+	})
+}
+
+func TestValidateMissingSecret(t *testing.T) {
+	_, err := testConfig(t).validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.secret: required") {
+		t.Errorf("validate error = %v, want auth.secret required", err)
+	}
+}
+
+func TestValidateRetiredSecret(t *testing.T) {
+	secret := strings.Repeat("s", minSecretLength)
+
+	_, err := testConfig(t, "-auth.keys_dir", "keys", "-auth.secret", secret).validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.secret_retired_at: required") {
+		t.Errorf("validate error = %v, want the retirement time required", err)
+	}
+
+	// the retirement time is read from the config, a restart does not extend the secret
+	retiredAt := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	warnings, err := testConfig(t, "-auth.keys_dir", "keys", "-auth.secret", secret,
+		"-auth.secret_retired_at", retiredAt, "-auth.token_ttl", "2h").validate()
+	if err != nil || strings.Contains(strings.Join(warnings, "\n"), "auth.secret") {
+		t.Errorf("validate = %q, %v, want the secret accepted within auth.token_ttl", warnings, err)
+	}
+}
+
+func TestValidateTOTPKey(t *testing.T) {
+	_, err := testConfig(t, "-auth.totp_key", "c2hvcnQ=").validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.totp_key: secret key: 5 bytes") {
+		t.Errorf("validate error = %v, want the key length", err)
+	}
+}
+
+func TestValidateMetricsAddr(t *testing.T) {
+	_, err := testConfig(t, "-server.metrics_addr", ":8080").validate()
+	if err == nil || !strings.Contains(err.Error(), "server.metrics_addr: must differ from server.addr") {
+		t.Errorf("validate error = %v, want the metrics address rejected", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+var errForcedShutdown = errors.New("shutdown deadline exceeded, remaining work was dropped")
+
+// workerGroup runs long living loops until shutdown,
+// they are stopped after http requests are drained
+type workerGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func newWorkerGroup() *workerGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &workerGroup{ctx: ctx, cancel: cancel, running: make(map[string]bool)}
+}
+
+func (g *workerGroup) setRunning(name string, running bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running[name] = running
+}
+
+// stopped returns names of started workers which are not running anymore
+func (g *workerGroup) stopped() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var names []string
+	for name, running := range g.running {
+		if !running {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (app *application) startWorker(name string, fn func(context.Context) error) {
+	app.workers.wg.Add(1)
+	app.workers.setRunning(name, true)
+	go func() {
+		defer app.workers.wg.Done()
+		defer app.workers.setRunning(name, false)
+
+		app.logger.Infow("worker start", "worker", name)
+		if err := fn(app.workers.ctx); err != nil {
+			app.logger.Errorw("worker failed", "worker", name, "error", err.Error())
+			return
+		}
+		app.logger.Infow("worker stop", "worker", name)
+	}()
+}
+
+// stopWorkers cancels workers and waits for them until ctx is done
+func (app *application) stopWorkers(ctx context.Context) error {
+	app.workers.cancel()
+	return waitContext(ctx, &app.workers.wg)
+}
+
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
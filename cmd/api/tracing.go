@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/critma/goblog/internal/store/instrument"
+	"github.com/critma/goblog/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// TracingMiddleware starts a server span per request, continuing the trace of an incoming traceparent
+func (app *application) TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := tracing.ParseTraceparent(r.Header.Get(tracing.TraceparentHeader)); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		ctx, span := app.tracer.Start(ctx, r.Method)
+		span.Kind = tracing.KindServer
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+		span.SetAttribute("client.address", clientIP(r))
+		span.SetAttribute("http.response.status_code", status)
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(r.Method + " " + rctx.RoutePattern())
+			span.SetAttribute("http.route", rctx.RoutePattern())
+		}
+		if status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(status)))
+		}
+	})
+}
+
+// storeTracer is an instrument.Observer creating a span per store call
+func storeTracer(tracer *tracing.Tracer) instrument.Observer {
+	return func(ctx context.Context, storeName, method string) (context.Context, func(error)) {
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("store.%s.%s", storeName, method))
+		span.Kind = tracing.KindClient
+		span.SetAttribute("db.system", "postgresql")
+
+		return ctx, func(err error) {
+			if storeCallFailed(err) {
+				span.RecordError(err)
+			}
+			span.End()
+		}
+	}
+}
+
+// trace id of the request, empty when there is none
+func traceID(r *http.Request) string {
+	if sc := tracing.SpanContextFromContext(r.Context()); sc.IsValid() {
+		return sc.TraceID.String()
+	}
+	return ""
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/critma/goblog/internal/auth"
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	twoFactorScope     = "2fa"
+	recoveryCodesCount = 10
+)
+
+var errInvalidSecondFactor = i18n.Error("twofactor.invalid_code")
+
+type TwoFactorChallenge struct {
+	ChallengeToken string `json:"challenge_token"`
+	// seconds
+	ExpiresIn int `json:"expires_in"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type TwoFactorCodePayload struct {
+	Code         string `json:"code" validate:"omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"omitempty,max=20"`
+}
+
+type TOTPCodePayload struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type VerifyTwoFactorPayload struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code" validate:"omitempty,len=6,numeric"`
+	RecoveryCode   string `json:"recovery_code" validate:"omitempty,max=20"`
+	// start a cookie session instead of returning a bearer token
+	Session bool `json:"session"`
+}
+
+type TwoFactorRequiredPayload struct {
+	Required bool `json:"required"`
+}
+
+// @Summary		Start two-factor enrollment
+// @Description	Generate TOTP secret, it is activated by /auth/2fa/confirm
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Success		200	{object}	TwoFactorEnrollment
+// @Failure		401	{object}	Problem
+// @Failure		409	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/2fa/enroll [post]
+func (app *application) enrollTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user := getUserFromContext(r)
+	if user.TwoFactor.Enabled {
+		app.conflictResponse(w, r, i18n.Error("twofactor.already_enabled"))
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	sealed, err := app.sealTOTPSecret(user.ID, secret)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.store.TwoFactor.SetSecret(r.Context(), user.ID, sealed); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	enrollment := TwoFactorEnrollment{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, app.config().auth.issuer, user.Email),
+	}
+	if err := app.jsonResponse(w, http.StatusOK, enrollment); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Confirm two-factor enrollment
+// @Description	Enable two-factor authentication with the first code from the authenticator app
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			code	body		TOTPCodePayload	true	"Code"
+// @Success		200		{object}	RecoveryCodes
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		409		{object}	Problem
+// @Failure		500		{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/2fa/confirm [post]
+func (app *application) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if user.TwoFactor.Enabled {
+		app.conflictResponse(w, r, i18n.Error("twofactor.already_enabled"))
+		return
+	}
+	if user.TwoFactor.Secret == "" {
+		app.badRequestResponse(w, r, i18n.Error("twofactor.enrollment_not_started"))
+		return
+	}
+
+	secret, err := app.openTOTPSecret(user)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	step, ok := auth.ValidateTOTP(secret, payload.Code, time.Now(), user.TwoFactor.LastStep)
+	if !ok {
+		app.badRequestResponse(w, r, errInvalidSecondFactor)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Enable(r.Context(), user.ID, step, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{Codes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Disable two-factor authentication
+// @Description	Disable two-factor authentication, not allowed when it is enforced
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			code	body	TwoFactorCodePayload	true	"Code or recovery code"
+// @Success		204
+// @Failure		400	{object}	Problem
+// @Failure		401	{object}	Problem
+// @Failure		403	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/2fa/disable [post]
+func (app *application) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TwoFactorCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if !user.TwoFactor.Enabled {
+		app.badRequestResponse(w, r, i18n.Error("twofactor.not_enabled"))
+		return
+	}
+	if app.twoFactorRequired(user) {
+		app.forbiddenResponse(w, r, i18n.Error("twofactor.required"))
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.verifySecondFactor(ctx, user, payload.Code, payload.RecoveryCode); err != nil {
+		app.secondFactorError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.Disable(ctx, user.ID); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary		Regenerate recovery codes
+// @Description	Replace all recovery codes, previous codes stop working
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			code	body		TOTPCodePayload	true	"Code"
+// @Success		200		{object}	RecoveryCodes
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		500		{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/auth/2fa/recovery-codes [post]
+func (app *application) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var payload TOTPCodePayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := getUserFromContext(r)
+	if !user.TwoFactor.Enabled {
+		app.badRequestResponse(w, r, i18n.Error("twofactor.not_enabled"))
+		return
+	}
+
+	ctx := r.Context()
+	if err := app.verifySecondFactor(ctx, user, payload.Code, ""); err != nil {
+		app.secondFactorError(w, r, err)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	if err := app.jsonResponse(w, http.StatusOK, RecoveryCodes{Codes: codes}); err != nil {
+		app.internalServerError(w, r, err)
+	}
+}
+
+// @Summary		Verify second factor
+// @Description	Second login step, exchanges challenge token and TOTP or recovery code for a token
+// @Tags			auth
+// @Accept			json
+// @Produce		json
+// @Param			payload	body		VerifyTwoFactorPayload	true	"Challenge"
+// @Success		202		{object}	string					"token, or CookieLogin with session"
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		429		{object}	Problem
+// @Failure		500		{object}	Problem
+// @Router			/auth/2fa/verify [post]
+func (app *application) verifyTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyTwoFactorPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := Validate.Struct(payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	jwtToken, err := app.authenticator.ValidateToken(payload.ChallengeToken)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	claims, _ := jwtToken.Claims.(jwt.MapClaims)
+	if scope, _ := claims["scope"].(string); scope != twoFactorScope {
+		app.unauthorizedErrorResponse(w, r, errors.New("not a two-factor challenge token"))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fmt.Sprintf("%.f", claims["sub"]), 10, 64)
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user, err := app.store.Users.GetByID(ctx, int(userID))
+	if err != nil {
+		app.unauthorizedErrorResponse(w, r, err)
+		return
+	}
+	if !user.TwoFactor.Enabled {
+		app.unauthorizedErrorResponse(w, r, errors.New("two-factor authentication is not enabled"))
+		return
+	}
+
+	// codes are guessed like passwords, failures count towards the same lockout
+	keys := newLoginKeys(r, user.Email)
+	attempt, wait, err := app.reserveLogin(ctx, keys)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if attempt == nil {
+		app.loginLockedResponse(w, r, wait)
+		return
+	}
+
+	if err := app.verifySecondFactor(ctx, user, payload.Code, payload.RecoveryCode); err != nil {
+		// only wrong codes are failures
+		var countErr error
+		if errors.Is(err, errInvalidSecondFactor) {
+			countErr = app.loginFailed(r, attempt, user)
+		} else {
+			countErr = app.releaseLogin(ctx, attempt)
+		}
+		if countErr != nil {
+			app.internalServerError(w, r, countErr)
+			return
+		}
+		app.secondFactorError(w, r, err)
+		return
+	}
+
+	if err := app.loginSucceeded(ctx, attempt); err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, user, payload.Session)
+}
+
+// @Summary		Enforce two-factor authentication
+// @Description	Require or stop requiring two-factor authentication for a user
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			id		path	int							true	"User ID"
+// @Param			payload	body	TwoFactorRequiredPayload	true	"Required"
+// @Success		204
+// @Failure		400	{object}	Problem
+// @Failure		403	{object}	Problem
+// @Failure		404	{object}	Problem
+// @Failure		500	{object}	Problem
+// @Security		ApiKeyAuth
+// @Router			/admin/users/{id}/2fa [put]
+func (app *application) setTwoFactorRequiredHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	var payload TwoFactorRequiredPayload
+	if err := readJSON(w, r, &payload); err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if err := app.store.TwoFactor.SetRequired(r.Context(), int(userID), payload.Required); err != nil {
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) twoFactorRequired(user *store.User) bool {
+	return user.TwoFactor.Required || slices.Contains(app.config().auth.twoFactorRoles, user.Role)
+}
+
+// verifySecondFactor checks TOTP code or, when it is empty, a recovery code.
+// Both are consumed, so each one works only once.
+func (app *application) verifySecondFactor(ctx context.Context, user *store.User, code, recoveryCode string) error {
+	var err error
+	switch {
+	case code != "":
+		secret, openErr := app.openTOTPSecret(user)
+		if openErr != nil {
+			return openErr
+		}
+		step, ok := auth.ValidateTOTP(secret, code, time.Now(), user.TwoFactor.LastStep)
+		if !ok {
+			return errInvalidSecondFactor
+		}
+		if err = app.store.TwoFactor.UseStep(ctx, user.ID, step); err == nil {
+			err = app.sealLegacyTOTPSecret(ctx, user, secret)
+		}
+	case recoveryCode != "":
+		err = app.store.TwoFactor.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(recoveryCode))
+	default:
+		return errInvalidSecondFactor
+	}
+
+	if errors.Is(err, store.ErrNotFound) {
+		return errInvalidSecondFactor
+	}
+	return err
+}
+
+// TOTP secrets are sealed with the id of their user, a secret copied to another row does not open
+func totpSecretContext(userID int) string {
+	return "totp:" + strconv.Itoa(userID)
+}
+
+func (app *application) sealTOTPSecret(userID int, secret string) (string, error) {
+	box, err := auth.NewSecretBox(app.config().auth.totpKey)
+	if err != nil {
+		return "", err
+	}
+	return box.Seal(secret, totpSecretContext(userID))
+}
+
+func (app *application) openTOTPSecret(user *store.User) (string, error) {
+	box, err := auth.NewSecretBox(app.config().auth.totpKey)
+	if err != nil {
+		return "", err
+	}
+	return box.Open(user.TwoFactor.Secret, totpSecretContext(user.ID))
+}
+
+// secrets stored before auth.totp_key was set are encrypted after their next use
+func (app *application) sealLegacyTOTPSecret(ctx context.Context, user *store.User, secret string) error {
+	if app.config().auth.totpKey == "" || auth.IsSealed(user.TwoFactor.Secret) {
+		return nil
+	}
+
+	sealed, err := app.sealTOTPSecret(user.ID, secret)
+	if err != nil {
+		return err
+	}
+	err = app.store.TwoFactor.ReplaceSecret(ctx, user.ID, user.TwoFactor.Secret, sealed)
+	if errors.Is(err, store.ErrNotFound) {
+		// enrolled again meanwhile
+		return nil
+	}
+	return err
+}
+
+func (app *application) secondFactorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errInvalidSecondFactor):
+		app.unauthorizedErrorResponse(w, r, err)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
@@ -12,15 +12,39 @@ type userKey string
 
 const userCtx userKey = "user"
 
-// @description	Get user by ID
+const (
+	includeArticles = "articles"
+
+	// newest published articles embedded per user
+	includedArticles = 5
+)
+
+var userIncludes = []string{includeArticles}
+
+// UserResponse is a user as the viewer may see it with the resources of ?include
+type UserResponse struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// shown to the user and admins only
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role"`
+	CreatedAt string `json:"created_at,omitempty"`
+
+	Articles []*store.Article `json:"articles,omitempty"`
+}
+
+// @description	Get user by ID, the email is returned to the user and admins only
 // @summary		Get user by ID
 // @Tags			users
 // @Accept			json
 // @Produce		json
-// @Param			id	path		int	true	"User ID"
-// @Success		200	{object}	store.User
-// @Failure		400	{object}	error
-// @Failure		404	{object}	error
+// @Param			id		path		int		true	"User ID"
+// @Param			fields	query		string	false	"comma separated fields to return"
+// @Param			include	query		string	false	"articles"
+// @Success		200		{object}	UserResponse
+// @Failure		400		{object}	Problem
+// @Failure		401		{object}	Problem
+// @Failure		404		{object}	Problem
 // @Router			/users/{id} [get]
 func (app *application) getUserByIDHandler(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
@@ -28,21 +52,45 @@ func (app *application) getUserByIDHandler(w http.ResponseWriter, r *http.Reques
 		app.badRequestResponse(w, r, err)
 		return
 	}
+	v, err := parseView(r, UserResponse{}, userIncludes)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
 
-	// user, err := app.getUser(r.Context(), userID)
-	user, err := app.store.Users.GetByID(r.Context(), int(userID))
+	ctx := r.Context()
+	user, err := app.store.Users.GetByID(ctx, int(userID))
 	if err != nil {
-		switch err {
-		case store.ErrNotFound:
-			app.notFoundResponse(w, r, err)
-			return
-		default:
+		app.storeErrorResponse(w, r, err)
+		return
+	}
+
+	viewer := getUserFromContext(r)
+	resp := &UserResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		CreatedAt: user.CreatedAt,
+	}
+	if canSeeEmail(user, viewer) {
+		resp.Email = user.Email
+	}
+
+	if v.includes(includeArticles) {
+		articles, err := app.store.Articles.GetByAuthor(ctx, user.ID, store.PaginatedQuery{Limit: includedArticles}, false)
+		if err != nil {
 			app.internalServerError(w, r, err)
 			return
 		}
+		resp.Articles = articles
 	}
 
-	if err := app.jsonResponse(w, http.StatusOK, user); err != nil {
+	projected, err := v.project(resp)
+	if err != nil {
+		app.internalServerError(w, r, err)
+		return
+	}
+	if err := app.jsonResponse(w, http.StatusOK, projected); err != nil {
 		app.internalServerError(w, r, err)
 	}
 }
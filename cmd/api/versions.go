@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/critma/goblog/internal/i18n"
+	"github.com/critma/goblog/internal/store"
+)
+
+var (
+	errVersionRequired = i18n.Error("article.version_required")
+	errVersionConflict = i18n.Error("article.version_conflict")
+)
+
+// strong ETag of the article version
+func articleETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// articleViewerETag tells apart the representations of an article version: likes and comments
+// are counted and the viewer likes and bookmarks without a new version.
+// If-Match only compares the version before the first dot.
+func articleViewerETag(article *store.Article, viewer *store.User) string {
+	tag := strconv.Itoa(article.Version) + "." + strconv.Itoa(article.Likes) + "." + strconv.Itoa(article.CommentsCount)
+	if viewer != nil {
+		flag := func(b *bool) string {
+			if b != nil && *b {
+				return "1"
+			}
+			return "0"
+		}
+		tag += "." + strconv.Itoa(viewer.ID) + "." + flag(article.LikedByMe) + flag(article.BookmarkedByMe)
+	}
+	return `"` + tag + `"`
+}
+
+// checkArticleVersion makes sure the client has seen the current article,
+// by If-Match header or, when there is none, by payloadVersion.
+// Responds with an error and returns false otherwise.
+func (app *application) checkArticleVersion(w http.ResponseWriter, r *http.Request, article *store.Article, payloadVersion int) bool {
+	ifMatch := r.Header.Get("If-Match")
+
+	switch {
+	case ifMatch != "":
+		if !ifMatchesVersion(ifMatch, article.Version) {
+			app.articleConflictResponse(w, r, article)
+			return false
+		}
+	case payloadVersion != 0:
+		if payloadVersion != article.Version {
+			app.articleConflictResponse(w, r, article)
+			return false
+		}
+	default:
+		app.preconditionRequiredResponse(w, r, errVersionRequired)
+		return false
+	}
+
+	return true
+}
+
+// strong comparison, as required for If-Match, of the version part of article ETags
+func ifMatchesVersion(header string, version int) bool {
+	etag := articleETag(version)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if v, _, ok := strings.Cut(candidate, "."); ok {
+			candidate = v + `"`
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// articleWriteError reports a failed versioned write, a conflict is answered with the current article
+func (app *application) articleWriteError(w http.ResponseWriter, r *http.Request, id int, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		app.notFoundResponse(w, r, err)
+	case errors.Is(err, store.ErrVersionConflict):
+		current, getErr := app.store.Articles.GetByID(r.Context(), id)
+		if getErr != nil {
+			app.articleWriteError(w, r, id, getErr)
+			return
+		}
+		app.articleConflictResponse(w, r, current)
+	default:
+		app.internalServerError(w, r, err)
+	}
+}
+
+func (app *application) articleConflictResponse(w http.ResponseWriter, r *http.Request, current *store.Article) {
+	w.Header().Set("ETag", articleETag(current.Version))
+	app.preconditionFailedResponse(w, r, errVersionConflict, current)
+}
@@ -5,4 +5,5 @@ import "github.com/golang-jwt/jwt/v5"
 type Authenticator interface {
 	GenerateToken(claims jwt.Claims) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
+	JWKS() JSONWebKeySet
 }
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"time"
+)
+
+// JSONWebKey is a public key in RFC 7517 format
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns public keys which verifiers may see in tokens,
+// including scheduled keys so that they are fetched before the rotation.
+// Symmetric keys are never published.
+func (j *JWTAuthenticator) JWKS() JSONWebKeySet {
+	now := time.Now()
+	set := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(j.keys))}
+
+	for _, k := range j.keys {
+		if k.isSymmetric() {
+			continue
+		}
+		if !k.NotAfter.IsZero() && !now.Before(k.NotAfter.Add(j.ttl)) {
+			continue
+		}
+		if jwk, ok := k.jwk(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
+func (k *Key) jwk() (JSONWebKey, bool) {
+	jwk := JSONWebKey{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	enc := base64.RawURLEncoding
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = enc.EncodeToString(pub.N.Bytes())
+		jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = enc.EncodeToString(pub)
+	case *ecdsa.PublicKey:
+		point, err := pub.ECDH()
+		if err != nil {
+			return jwk, false
+		}
+		// uncompressed point: 0x04 || X || Y
+		raw := point.Bytes()[1:]
+		size := len(raw) / 2
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = enc.EncodeToString(raw[:size])
+		jwk.Y = enc.EncodeToString(raw[size:])
+	default:
+		return jwk, false
+	}
+	return jwk, true
+}
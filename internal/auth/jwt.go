@@ -2,24 +2,39 @@ package auth
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type JWTAuthenticator struct {
-	secret string
+	keys   []*Key
+	ttl    time.Duration
 	aud    string
 	issuer string
 }
 
 func NewJWTAuthenticator(secret, aud, issuer string) *JWTAuthenticator {
-	return &JWTAuthenticator{secret, aud, issuer}
+	return NewJWTAuthenticatorWithKeys([]*Key{NewHMACKey("", []byte(secret))}, 0, aud, issuer)
+}
+
+// ttl is the lifetime of issued tokens, retired keys keep validating for this long
+func NewJWTAuthenticatorWithKeys(keys []*Key, ttl time.Duration, aud, issuer string) *JWTAuthenticator {
+	return &JWTAuthenticator{keys, ttl, aud, issuer}
 }
 
 func (j *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	key, err := j.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
 
-	tokenString, err := token.SignedString([]byte(j.secret))
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+
+	tokenString, err := token.SignedString(key.private)
 	if err != nil {
 		return "", err
 	}
@@ -29,14 +44,53 @@ func (j *JWTAuthenticator) GenerateToken(claims jwt.Claims) (string, error) {
 
 func (j *JWTAuthenticator) ValidateToken(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, _ := t.Header["kid"].(string)
+		key, err := j.verificationKey(kid, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 		}
-		return []byte(j.secret), nil
+		return key.public, nil
 	},
 		jwt.WithExpirationRequired(),
 		jwt.WithAudience(j.aud),
 		jwt.WithIssuer(j.issuer),
-		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Name}),
+		jwt.WithValidMethods(j.methods()),
 	)
 }
+
+// newest key which is allowed to sign at the moment
+func (j *JWTAuthenticator) signingKey(now time.Time) (*Key, error) {
+	var active *Key
+	for _, k := range j.keys {
+		if !k.canSign(now) {
+			continue
+		}
+		if active == nil || k.NotBefore.After(active.NotBefore) {
+			active = k
+		}
+	}
+	if active == nil {
+		return nil, ErrNoSigningKey
+	}
+	return active, nil
+}
+
+func (j *JWTAuthenticator) verificationKey(kid string, now time.Time) (*Key, error) {
+	for _, k := range j.keys {
+		if k.ID == kid && k.canVerify(now, j.ttl) {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+func (j *JWTAuthenticator) methods() []string {
+	methods := make([]string, 0, len(j.keys))
+	for _, k := range j.keys {
+		methods = append(methods, k.Method.Alg())
+	}
+	return methods
+}
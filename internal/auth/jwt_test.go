@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": 1,
+		"aud": "blog",
+		"iss": "blog",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func newEd25519Key(t *testing.T, id string) *Key {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodEdDSA, private: priv, public: pub}
+}
+
+func newECKey(t *testing.T, id string, curve elliptic.Curve) *Key {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &Key{ID: id, private: priv, public: &priv.PublicKey}
+	if key.Method, err = ecdsaMethod(curve); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newRSAKey(t *testing.T, id string) *Key {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Key{ID: id, Method: jwt.SigningMethodRS256, private: priv, public: &priv.PublicKey}
+}
+
+// signWith signs claims by key regardless of its schedule
+func signWith(t *testing.T, key *Key, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	s, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func writePEM(t *testing.T, dir, name string, block *pem.Block) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadKeysFromDir(t *testing.T) {
+	_, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkix, err := x509.MarshalPKIXPublicKey(&ecPriv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notBefore := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		file    string
+		block   *pem.Block
+		wantID  string
+		wantAlg string
+		// keys without a private part only verify
+		wantSign  bool
+		notBefore time.Time
+		notAfter  time.Time
+		wantErr   string
+	}{
+		{
+			name:     "id from the file name",
+			file:     "2025-10.pem",
+			block:    &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8},
+			wantID:   "2025-10",
+			wantAlg:  "EdDSA",
+			wantSign: true,
+		},
+		{
+			name: "headers",
+			file: "current.pem",
+			block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1, Headers: map[string]string{
+				"Kid":        "ec-2025",
+				"Not-Before": notBefore.Format(time.RFC3339),
+				"Not-After":  notAfter.Format(time.RFC3339),
+			}},
+			wantID:    "ec-2025",
+			wantAlg:   "ES256",
+			wantSign:  true,
+			notBefore: notBefore,
+			notAfter:  notAfter,
+		},
+		{
+			name:    "public key only",
+			file:    "partner.pem",
+			block:   &pem.Block{Type: "PUBLIC KEY", Bytes: pkix},
+			wantID:  "partner",
+			wantAlg: "ES256",
+		},
+		{
+			name:    "invalid schedule",
+			file:    "bad.pem",
+			block:   &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8, Headers: map[string]string{"Not-Before": "2025-10-01"}},
+			wantErr: "invalid Not-Before",
+		},
+		{
+			name:    "unsupported block",
+			file:    "cert.pem",
+			block:   &pem.Block{Type: "CERTIFICATE", Bytes: pkix},
+			wantErr: ErrUnsupportedKey.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writePEM(t, dir, tt.file, tt.block)
+			// files of other types are ignored
+			if err := os.WriteFile(filepath.Join(dir, "readme.txt"), []byte("keys"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := LoadKeysFromDir(dir)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(keys) != 1 {
+				t.Fatalf("loaded %d keys, want 1", len(keys))
+			}
+
+			k := keys[0]
+			if k.ID != tt.wantID || k.Method.Alg() != tt.wantAlg {
+				t.Errorf("key = %q %s, want %q %s", k.ID, k.Method.Alg(), tt.wantID, tt.wantAlg)
+			}
+			if (k.private != nil) != tt.wantSign {
+				t.Errorf("private part loaded = %v, want %v", k.private != nil, tt.wantSign)
+			}
+			if !k.NotBefore.Equal(tt.notBefore) || !k.NotAfter.Equal(tt.notAfter) {
+				t.Errorf("schedule = %v..%v, want %v..%v", k.NotBefore, k.NotAfter, tt.notBefore, tt.notAfter)
+			}
+		})
+	}
+
+	t.Run("duplicate id", func(t *testing.T) {
+		dir := t.TempDir()
+		writePEM(t, dir, "a.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8, Headers: map[string]string{"Kid": "main"}})
+		writePEM(t, dir, "b.pem", &pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1, Headers: map[string]string{"Kid": "main"}})
+		if _, err := LoadKeysFromDir(dir); err == nil || !strings.Contains(err.Error(), "duplicate key id") {
+			t.Errorf("error = %v, want the duplicate key id", err)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if _, err := LoadKeysFromDir(t.TempDir()); err == nil || !strings.Contains(err.Error(), "no keys found") {
+			t.Errorf("error = %v, want no keys found", err)
+		}
+	})
+}
+
+func TestSigningKey(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	day := 24 * time.Hour
+
+	scheduled := func(id string, notBefore, notAfter time.Duration) *Key {
+		k := newEd25519Key(t, id)
+		k.NotBefore = now.Add(notBefore)
+		if notAfter != 0 {
+			k.NotAfter = now.Add(notAfter)
+		}
+		return k
+	}
+	publicOnly := scheduled("public", 0, 0)
+	publicOnly.private = nil
+
+	tests := []struct {
+		name string
+		keys []*Key
+		want string
+	}{
+		{name: "newest active", keys: []*Key{scheduled("old", -60*day, 0), scheduled("new", -day, 0)}, want: "new"},
+		{name: "order does not matter", keys: []*Key{scheduled("new", -day, 0), scheduled("old", -60*day, 0)}, want: "new"},
+		{name: "scheduled key waits", keys: []*Key{scheduled("current", -day, 0), scheduled("next", day, 0)}, want: "current"},
+		{name: "retired key stops signing", keys: []*Key{scheduled("retired", -60*day, -time.Hour), scheduled("older", -90*day, 0)}, want: "older"},
+		{name: "public key never signs", keys: []*Key{scheduled("private", -day, 0), publicOnly}, want: "private"},
+		{name: "none active", keys: []*Key{scheduled("next", day, 0), scheduled("retired", -day, -time.Hour)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := NewJWTAuthenticatorWithKeys(tt.keys, time.Hour, "blog", "blog").signingKey(now)
+			if tt.want == "" {
+				if !errors.Is(err, ErrNoSigningKey) {
+					t.Errorf("error = %v, want ErrNoSigningKey", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.ID != tt.want {
+				t.Errorf("signing key = %q, want %q", key.ID, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTokenAfterRotation(t *testing.T) {
+	ttl := time.Hour
+
+	tests := []struct {
+		name string
+		// how long ago the old key stopped signing
+		retired time.Duration
+		wantErr error
+	}{
+		{name: "still signing", retired: -time.Hour},
+		{name: "within the token lifetime", retired: ttl / 2},
+		{name: "after the token lifetime", retired: ttl + time.Minute, wantErr: ErrUnknownKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			old := newECKey(t, "2025-04", elliptic.P256())
+			old.NotBefore = now.Add(-180 * 24 * time.Hour)
+			old.NotAfter = now.Add(-tt.retired)
+			current := newEd25519Key(t, "2025-10")
+			current.NotBefore = now.Add(-tt.retired)
+
+			j := NewJWTAuthenticatorWithKeys([]*Key{current, old}, ttl, "blog", "blog")
+			_, err := j.ValidateToken(signWith(t, old, testClaims()))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("token of the old key: error = %v, want %v", err, tt.wantErr)
+			}
+
+			token, err := j.GenerateToken(testClaims())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := j.ValidateToken(token); err != nil {
+				t.Errorf("token of the current key: %v", err)
+			}
+		})
+	}
+
+	t.Run("unknown kid", func(t *testing.T) {
+		j := NewJWTAuthenticatorWithKeys([]*Key{newEd25519Key(t, "2025-10")}, ttl, "blog", "blog")
+		if _, err := j.ValidateToken(signWith(t, newEd25519Key(t, "other"), testClaims())); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("error = %v, want ErrUnknownKey", err)
+		}
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	ttl := time.Hour
+	rsaKey := newRSAKey(t, "rsa")
+	p256 := newECKey(t, "p256", elliptic.P256())
+	p384 := newECKey(t, "p384", elliptic.P384())
+	scheduled := newECKey(t, "scheduled", elliptic.P256())
+	scheduled.NotBefore = time.Now().Add(24 * time.Hour)
+	expired := newECKey(t, "expired", elliptic.P256())
+	expired.NotAfter = time.Now().Add(-2 * ttl)
+
+	keys := []*Key{rsaKey, p256, p384, scheduled, expired, NewHMACKey("hmac", []byte(testSecret))}
+	set := NewJWTAuthenticatorWithKeys(keys, ttl, "blog", "blog").JWKS()
+
+	got := make(map[string]JSONWebKey, len(set.Keys))
+	for _, k := range set.Keys {
+		got[k.Kid] = k
+	}
+	for _, kid := range []string{"hmac", "expired"} {
+		if _, ok := got[kid]; ok {
+			t.Errorf("key %q is published", kid)
+		}
+	}
+
+	enc := base64.RawURLEncoding
+	tests := []struct {
+		kid  string
+		want JSONWebKey
+	}{
+		{
+			kid: "rsa",
+			want: JSONWebKey{
+				Kty: "RSA", Kid: "rsa", Use: "sig", Alg: "RS256",
+				N: enc.EncodeToString(rsaKey.public.(*rsa.PublicKey).N.Bytes()),
+				E: "AQAB",
+			},
+		},
+		{kid: "p256", want: ecJWK(t, p256, "P-256", 32)},
+		{kid: "p384", want: ecJWK(t, p384, "P-384", 48)},
+		{kid: "scheduled", want: ecJWK(t, scheduled, "P-256", 32)},
+	}
+
+	for _, tt := range tests {
+		jwk, ok := got[tt.kid]
+		if !ok {
+			t.Errorf("key %q is not published", tt.kid)
+			continue
+		}
+		if jwk != tt.want {
+			t.Errorf("key %q = %+v, want %+v", tt.kid, jwk, tt.want)
+		}
+	}
+}
+
+// ecJWK builds the expected key from the fixed-size coordinates of the curve
+func ecJWK(t *testing.T, k *Key, crv string, size int) JSONWebKey {
+	t.Helper()
+	pub := k.public.(*ecdsa.PublicKey)
+	x, y := make([]byte, size), make([]byte, size)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	enc := base64.RawURLEncoding
+	return JSONWebKey{
+		Kty: "EC", Kid: k.ID, Use: "sig", Alg: k.Method.Alg(),
+		Crv: crv, X: enc.EncodeToString(x), Y: enc.EncodeToString(y),
+	}
+}
+
+func TestRetiredHMACKey(t *testing.T) {
+	legacy, err := NewJWTAuthenticator(testSecret, "blog", "blog").GenerateToken(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ttl := time.Hour
+	keys := []*Key{newEd25519Key(t, "2025-10"), NewRetiredHMACKey([]byte(testSecret), time.Now())}
+	j := NewJWTAuthenticatorWithKeys(keys, ttl, "blog", "blog")
+
+	if _, err := j.ValidateToken(legacy); err != nil {
+		t.Errorf("token signed by the secret before the switch: %v", err)
+	}
+
+	token, err := j.GenerateToken(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := j.ValidateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Method.Alg() != "EdDSA" || parsed.Header["kid"] != "2025-10" {
+		t.Errorf("new token is signed by %v with kid %v, want the key of the directory", parsed.Method.Alg(), parsed.Header["kid"])
+	}
+
+	expired := NewJWTAuthenticatorWithKeys(
+		[]*Key{keys[0], NewRetiredHMACKey([]byte(testSecret), time.Now().Add(-2*ttl))}, ttl, "blog", "blog",
+	)
+	if _, err := expired.ValidateToken(legacy); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token signed by the secret after the token lifetime: error = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestRetiredHMACKeyDoesNotSign(t *testing.T) {
+	j := NewJWTAuthenticatorWithKeys([]*Key{NewRetiredHMACKey([]byte(testSecret), time.Now())}, time.Hour, "blog", "blog")
+	if _, err := j.GenerateToken(testClaims()); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("GenerateToken error = %v, want ErrNoSigningKey", err)
+	}
+	if keys := j.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("JWKS publishes %d keys, want the secret hidden", len(keys))
+	}
+}
+
+func TestRetiredHMACKeyAfterRestart(t *testing.T) {
+	legacy, err := NewJWTAuthenticator(testSecret, "blog", "blog").GenerateToken(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every restart builds the key from the same configured time, it does not move the deadline
+	ttl := time.Hour
+	retiredAt := time.Now().Add(-ttl - time.Minute)
+	signing := newEd25519Key(t, "2025-10")
+	for restart := range 3 {
+		j := NewJWTAuthenticatorWithKeys([]*Key{signing, NewRetiredHMACKey([]byte(testSecret), retiredAt)}, ttl, "blog", "blog")
+		if _, err := j.ValidateToken(legacy); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("restart %d: token signed by the secret after the token lifetime: error = %v, want ErrUnknownKey", restart, err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey   = errors.New("no active signing key")
+	ErrUnknownKey     = errors.New("unknown signing key")
+	ErrUnsupportedKey = errors.New("unsupported key type")
+)
+
+// Key is a single signing/verification key of the key set.
+// Key is used for signing between NotBefore and NotAfter
+// and keeps validating tokens for the token lifetime after NotAfter.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	private any
+	public  any
+}
+
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:      id,
+		Method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// NewRetiredHMACKey signs nothing and verifies tokens signed by secret before retired
+// for the token lifetime, e.g. after the switch from the secret to a key directory
+func NewRetiredHMACKey(secret []byte, retired time.Time) *Key {
+	key := NewHMACKey("", secret)
+	key.NotAfter = retired
+	return key
+}
+
+func (k *Key) canSign(now time.Time) bool {
+	if k.private == nil || now.Before(k.NotBefore) {
+		return false
+	}
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+func (k *Key) canVerify(now time.Time, ttl time.Duration) bool {
+	if now.Before(k.NotBefore) {
+		return false
+	}
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter.Add(ttl))
+}
+
+func (k *Key) isSymmetric() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// LoadKeysFromDir reads every *.pem file of dir.
+// Key id is taken from the "Kid" PEM header or the file name,
+// rotation schedule from the optional "Not-Before" and "Not-After" headers (RFC 3339).
+func LoadKeysFromDir(dir string) ([]*Key, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(files))
+	seen := make(map[string]bool)
+	for _, file := range files {
+		key, err := LoadKeyFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("%s: duplicate key id %q", file, key.ID)
+		}
+		seen[key.ID] = true
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", dir)
+	}
+	return keys, nil
+}
+
+func LoadKeyFromFile(file string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	key := &Key{ID: block.Headers["Kid"]}
+	if key.ID == "" {
+		key.ID = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	if v, ok := block.Headers["Not-Before"]; ok {
+		if key.NotBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid Not-Before: %w", err)
+		}
+	}
+	if v, ok := block.Headers["Not-After"]; ok {
+		if key.NotAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, fmt.Errorf("invalid Not-After: %w", err)
+		}
+	}
+
+	if err := key.parse(block); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *Key) parse(block *pem.Block) error {
+	var (
+		raw any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		raw, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		raw, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		raw, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		raw, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return fmt.Errorf("%w: PEM block %q", ErrUnsupportedKey, block.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch key := raw.(type) {
+	case *rsa.PrivateKey:
+		k.Method, k.private, k.public = jwt.SigningMethodRS256, key, &key.PublicKey
+	case *rsa.PublicKey:
+		k.Method, k.public = jwt.SigningMethodRS256, key
+	case ed25519.PrivateKey:
+		k.Method, k.private, k.public = jwt.SigningMethodEdDSA, key, key.Public()
+	case ed25519.PublicKey:
+		k.Method, k.public = jwt.SigningMethodEdDSA, key
+	case *ecdsa.PrivateKey:
+		k.private, k.public = key, &key.PublicKey
+		k.Method, err = ecdsaMethod(key.Curve)
+	case *ecdsa.PublicKey:
+		k.public = key
+		k.Method, err = ecdsaMethod(key.Curve)
+	default:
+		return fmt.Errorf("%w: %T", ErrUnsupportedKey, raw)
+	}
+	return err
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	}
+	return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, curve.Params().Name)
+}
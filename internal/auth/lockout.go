@@ -0,0 +1,45 @@
+package auth
+
+import "time"
+
+// LockoutPolicy slows down password guessing:
+// after FreeAttempts failures every next attempt waits BaseDelay doubled per failure,
+// every LockAfter failures the key is locked for LockFor.
+// Failures are forgotten ResetAfter the last one.
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockAfter    int
+	LockFor      time.Duration
+	ResetAfter   time.Duration
+}
+
+// Delay is the minimal pause after the last failure
+func (p LockoutPolicy) Delay(failures int) time.Duration {
+	over := failures - p.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < over && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+func (p LockoutPolicy) ShouldLock(failures int) bool {
+	return p.LockAfter > 0 && failures > 0 && failures%p.LockAfter == 0
+}
+
+// Wait returns how long the next attempt has to wait, zero when it is allowed now
+func (p LockoutPolicy) Wait(failures int, lastFailure, lockedUntil, now time.Time) time.Duration {
+	if now.Before(lockedUntil) {
+		return lockedUntil.Sub(now)
+	}
+	if failures == 0 || now.Sub(lastFailure) >= p.ResetAfter {
+		return 0
+	}
+	return max(0, lastFailure.Add(p.Delay(failures)).Sub(now))
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealed values start with the version of the format, values stored before encryption do not
+const sealedPrefix = "v1:"
+
+var ErrSecretKeyRequired = errors.New("secret is encrypted, the key is not configured")
+
+// SecretBox encrypts short secrets stored in the database with AES-256-GCM.
+// A nil box stores them as they are.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox takes a base64 encoded 32 byte key, an empty key returns a nil box
+func NewSecretBox(key string) (*SecretBox, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("secret key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("secret key: %d bytes, 32 are required", len(raw))
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts secret bound to context, e.g. the owner of the row,
+// so a sealed value copied to another row does not open
+func (b *SecretBox) Seal(secret, context string) (string, error) {
+	if b == nil {
+		return secret, nil
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), []byte(context))
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a sealed value, a value stored before encryption is returned as it is
+func (b *SecretBox) Open(value, context string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, sealedPrefix)
+	if !ok {
+		return value, nil
+	}
+	if b == nil {
+		return "", ErrSecretKeyRequired
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	size := b.aead.NonceSize()
+	if len(sealed) < size {
+		return "", errors.New("sealed secret is too short")
+	}
+	secret, err := b.aead.Open(nil, sealed[:size], sealed[size:], []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// IsSealed tells values stored before encryption apart
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
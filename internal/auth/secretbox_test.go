@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(b), 32)))
+}
+
+func TestSecretBoxRoundTrip(t *testing.T) {
+	box, err := NewSecretBox(testKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := box.Seal(rfcSecret, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, rfcSecret) {
+		t.Fatalf("sealed value %q is not encrypted", sealed)
+	}
+
+	again, err := box.Seal(rfcSecret, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing twice gives the same value, the nonce is reused")
+	}
+
+	opened, err := box.Open(sealed, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != rfcSecret {
+		t.Errorf("Open = %q, want %q", opened, rfcSecret)
+	}
+}
+
+func TestSecretBoxOpenFails(t *testing.T) {
+	box, err := NewSecretBox(testKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewSecretBox(testKey('o'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal(rfcSecret, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		box     *SecretBox
+		value   string
+		context string
+	}{
+		{name: "other row", box: box, value: sealed, context: "totp:2"},
+		{name: "other key", box: other, value: sealed, context: "totp:1"},
+		{name: "tampered", box: box, value: sealed[:len(sealed)-2] + "AA", context: "totp:1"},
+		{name: "truncated", box: box, value: sealedPrefix + "AAAA", context: "totp:1"},
+		{name: "not base64", box: box, value: sealedPrefix + "!!", context: "totp:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.box.Open(tt.value, tt.context); err == nil {
+				t.Error("Open: want an error")
+			}
+		})
+	}
+}
+
+func TestSecretBoxWithoutKey(t *testing.T) {
+	box, err := NewSecretBox("")
+	if err != nil || box != nil {
+		t.Fatalf("NewSecretBox(\"\") = %v, %v, want a nil box", box, err)
+	}
+
+	stored, err := box.Seal(rfcSecret, "totp:1")
+	if err != nil || stored != rfcSecret {
+		t.Errorf("Seal without a key = %q, %v, want the secret as it is", stored, err)
+	}
+
+	keyed, err := NewSecretBox(testKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keyed.Seal(rfcSecret, "totp:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Open(sealed, "totp:1"); !errors.Is(err, ErrSecretKeyRequired) {
+		t.Errorf("Open of a sealed value without a key: error = %v, want ErrSecretKeyRequired", err)
+	}
+}
+
+func TestSecretBoxOpensLegacyValues(t *testing.T) {
+	box, err := NewSecretBox(testKey('k'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := box.Open(rfcSecret, "totp:1")
+	if err != nil || opened != rfcSecret {
+		t.Errorf("Open of a plain value = %q, %v, want it as it is", opened, err)
+	}
+	if IsSealed(rfcSecret) {
+		t.Error("a plain value is reported as sealed")
+	}
+}
+
+func TestNewSecretBoxInvalidKey(t *testing.T) {
+	for _, key := range []string{"not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := NewSecretBox(key); err == nil {
+			t.Errorf("NewSecretBox(%q): want an error", key)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// NewSessionToken returns a random session cookie value and the hash to store,
+// a leaked sessions table does not let anyone in
+func NewSessionToken() (token, hash string) {
+	token = rand.Text()
+	return token, HashSessionToken(token)
+}
+
+// session tokens have enough entropy for a fast hash
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewCSRFToken returns a token sent back by scripts of the session in a header
+func NewCSRFToken() string {
+	return rand.Text()
+}
+
+func ValidCSRFToken(expected, got string) bool {
+	return got != "" && subtle.ConstantTimeCompare([]byte(expected), []byte(got)) == 1
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// base32 of the ascii "12345678901234567890", the sha1 key of RFC 6238 appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// the RFC lists 8 digits, 6 digit codes are their last digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		step := TOTPStep(time.Unix(tt.unix, 0))
+		code, err := TOTPCode(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", tt.unix, err)
+		}
+		if code != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, err := TOTPCode(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := TOTPCode(strings.ToLower(rfcSecret), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("lowercase secret gives %s, want %s", lower, upper)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode of an invalid secret: want an error")
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		ok       bool
+	}{
+		{name: "current step", code: code(current), ok: true, wantStep: current},
+		{name: "previous step within skew", code: code(current - TOTPSkew), ok: true, wantStep: current - TOTPSkew},
+		{name: "next step within skew", code: code(current + TOTPSkew), ok: true, wantStep: current + TOTPSkew},
+		{name: "older than skew", code: code(current - TOTPSkew - 1)},
+		{name: "newer than skew", code: code(current + TOTPSkew + 1)},
+		{name: "spaces are trimmed", code: " " + code(current) + " ", ok: true, wantStep: current},
+		{name: "replayed step", code: code(current), lastStep: current},
+		{name: "earlier step after a later one", code: code(current - 1), lastStep: current},
+		{name: "later step after an earlier one", code: code(current), lastStep: current - 1, ok: true, wantStep: current},
+		{name: "code of another time", code: code(current + 100)},
+		{name: "short code", code: code(current)[:5]},
+		{name: "long code", code: code(current) + "0"},
+		{name: "empty code", code: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfcSecret, tt.code, now, tt.lastStep)
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("ValidateTOTP step = %d, want %d", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := b32.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	// RFC 4226 recommends 160 bits
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("%d codes, want 10", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		first, second, ok := strings.Cut(code, "-")
+		if !ok || len(first) != 5 || len(second) != 5 || code != strings.ToLower(code) {
+			t.Errorf("code %q is not formatted as xxxxx-xxxxx", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q is repeated", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := HashRecoveryCode("abcde-fghij")
+
+	for _, same := range []string{"abcdefghij", "ABCDE-FGHIJ", "  abcde-fghij\n", "abc-de-fghij"} {
+		if got := HashRecoveryCode(same); got != hash {
+			t.Errorf("HashRecoveryCode(%q) differs from the one of abcde-fghij", same)
+		}
+	}
+	if HashRecoveryCode("abcde-fghik") == hash {
+		t.Error("different codes have the same hash")
+	}
+	if strings.Contains(hash, "abcde") || len(hash) != 64 {
+		t.Errorf("hash %q is not a hex sha256", hash)
+	}
+}
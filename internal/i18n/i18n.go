@@ -0,0 +1,191 @@
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+// catalogs are named by language tag, a message is a fmt format
+// or an object of plural forms chosen by the first numeric argument
+//
+//go:embed locales/*.json
+var locales embed.FS
+
+// Fallback is used when nothing in Accept-Language is supported
+// and for messages missing in a catalog
+var Fallback = language.English
+
+var (
+	catalogs = map[language.Tag]map[string]message{}
+	// supported languages in matcher order
+	tags    []language.Tag
+	matcher language.Matcher
+)
+
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+func init() {
+	files, err := locales.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+
+	// fallback first, the matcher prefers earlier tags on ties
+	tags = []language.Tag{Fallback}
+	for _, f := range files {
+		tag := language.MustParse(strings.TrimSuffix(f.Name(), path.Ext(f.Name())))
+		catalog, err := loadCatalog(path.Join("locales", f.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", f.Name(), err))
+		}
+		catalogs[tag] = catalog
+		if tag != Fallback {
+			tags = append(tags, tag)
+		}
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+func loadCatalog(name string) (map[string]message, error) {
+	data, err := locales.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	catalog := make(map[string]message, len(raw))
+	for key, value := range raw {
+		var m message
+		if err := json.Unmarshal(value, &m.text); err != nil {
+			if err := json.Unmarshal(value, &m.forms); err != nil || m.forms["other"] == "" {
+				return nil, fmt.Errorf("%s: must be a string or plural forms with \"other\"", key)
+			}
+		}
+		catalog[key] = m
+	}
+	return catalog, nil
+}
+
+// Negotiate picks a supported language of an Accept-Language header
+func Negotiate(acceptLanguage string) language.Tag {
+	prefs, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(prefs) == 0 {
+		return Fallback
+	}
+	_, index, confidence := matcher.Match(prefs...)
+	if confidence == language.No {
+		return Fallback
+	}
+	return tags[index]
+}
+
+// T formats the message of key in lang, missing messages fall back to Fallback and then to the key
+func T(lang language.Tag, key string, args ...any) string {
+	m, ok := catalogs[lang][key]
+	if !ok {
+		lang = Fallback
+		if m, ok = catalogs[Fallback][key]; !ok {
+			return key
+		}
+	}
+
+	format := m.text
+	if m.forms != nil {
+		format = m.forms["other"]
+		if form, ok := m.forms[pluralForm(lang, count(args))]; ok {
+			format = form
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// first argument which is a whole number, -1 when there is none
+func count(args []any) int {
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case int:
+			return v
+		case int64:
+			return int(v)
+		case string:
+			if n, err := strconv.Atoi(v); err == nil {
+				return n
+			}
+		}
+	}
+	return -1
+}
+
+// CLDR cardinal plural categories of the supported languages
+func pluralForm(lang language.Tag, n int) string {
+	if n < 0 {
+		return "other"
+	}
+	base, _ := lang.Base()
+	switch base.String() {
+	case "ru":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
+
+// Message is an error shown to clients, responses translate it to their language
+type Message struct {
+	Key  string
+	Args []any
+}
+
+func Error(key string, args ...any) *Message {
+	return &Message{Key: key, Args: args}
+}
+
+// Error is the fallback text, used in logs
+func (m *Message) Error() string {
+	return T(Fallback, m.Key, m.Args...)
+}
+
+func (m *Message) Localize(lang language.Tag) string {
+	return T(lang, m.Key, m.Args...)
+}
+
+type langKey struct{}
+
+func NewContext(ctx context.Context, lang language.Tag) context.Context {
+	return context.WithValue(ctx, langKey{}, lang)
+}
+
+// FromContext returns the negotiated language of the request, or Fallback
+func FromContext(ctx context.Context) language.Tag {
+	if lang, ok := ctx.Value(langKey{}).(language.Tag); ok {
+		return lang
+	}
+	return Fallback
+}
@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type loggerKey struct{}
+
+// NewContext returns ctx carrying a logger with request fields
+func NewContext(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the request logger, or fallback outside of requests
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// With adds fields to the logger of ctx
+func With(ctx context.Context, fallback *zap.SugaredLogger, args ...any) context.Context {
+	return NewContext(ctx, FromContext(ctx, fallback).With(args...))
+}
@@ -0,0 +1,105 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrInvalidHeader = errors.New("invalid mail header")
+
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{host, port, username, password, from}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	msg, err := m.message(to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	// envelope takes bare addresses, headers may contain display names
+	from, err := mail.ParseAddress(m.from)
+	if err != nil {
+		return err
+	}
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	// net/smtp has no context support, the result is dropped when ctx is done
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, from.Address, []string{rcpt.Address}, msg)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *SMTPMailer) message(to, subject, body string) ([]byte, error) {
+	for _, v := range []string{m.from, to, subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
+
+// LogMailer writes mails to the log, used when SMTP is not configured
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogMailer(logger *zap.SugaredLogger) *LogMailer {
+	return &LogMailer{logger}
+}
+
+func (m *LogMailer) Send(_ context.Context, to, subject, body string) error {
+	m.logger.Infow("mail", "to", to, "subject", subject, "body", body)
+	return nil
+}
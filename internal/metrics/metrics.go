@@ -0,0 +1,312 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Kind string
+
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// DefaultBuckets suit request and query latencies in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type sample struct {
+	suffix string
+	labels []string // names and values interleaved
+	value  float64
+}
+
+type metric interface {
+	name() string
+	help() string
+	kind() Kind
+	collect() []sample
+}
+
+// Registry renders registered metrics in Prometheus text exposition format
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic("metrics: duplicate metric " + m.name())
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec: newVec[float64](name, help, labels)}
+	r.register(c)
+	return c
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{vec: newVec[*histogramValue](name, help, labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// NewFunc registers a metric read on every scrape, fn emits one value per label set
+func (r *Registry) NewFunc(name, help string, kind Kind, labels []string, fn func(emit func(value float64, labelValues ...string))) {
+	r.register(&funcMetric{name_: name, help_: help, kind_: kind, labels: labels, fn: fn})
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		fmt.Fprintf(cw, "# HELP %s %s\n", m.name(), escapeHelp(m.help()))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", m.name(), m.kind())
+		for _, s := range m.collect() {
+			cw.WriteString(m.name() + s.suffix)
+			writeLabels(cw, s.labels)
+			cw.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	if err := cw.w.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, cw.err
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// vec keeps one value per combination of label values
+type vec[V any] struct {
+	name_  string
+	help_  string
+	labels []string
+
+	mu     sync.Mutex
+	keys   []string
+	values map[string]V
+	lvs    map[string][]string
+}
+
+func newVec[V any](name, help string, labels []string) *vec[V] {
+	return &vec[V]{
+		name_:  name,
+		help_:  help,
+		labels: labels,
+		values: make(map[string]V),
+		lvs:    make(map[string][]string),
+	}
+}
+
+func (v *vec[V]) name() string { return v.name_ }
+func (v *vec[V]) help() string { return v.help_ }
+
+// update calls fn with the value of labelValues under the lock
+func (v *vec[V]) update(labelValues []string, init func() V, fn func(V) V) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name_, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cur, ok := v.values[key]
+	if !ok {
+		cur = init()
+		v.keys = append(v.keys, key)
+		v.lvs[key] = slices.Clone(labelValues)
+	}
+	v.values[key] = fn(cur)
+}
+
+func (v *vec[V]) pairs(key string) []string {
+	return interleave(v.labels, v.lvs[key])
+}
+
+type Counter struct {
+	*vec[float64]
+}
+
+func (c *Counter) kind() Kind { return KindCounter }
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	c.update(labelValues, func() float64 { return 0 }, func(v float64) float64 { return v + delta })
+}
+
+func (c *Counter) collect() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := make([]sample, 0, len(c.keys))
+	for _, key := range c.keys {
+		samples = append(samples, sample{labels: c.pairs(key), value: c.values[key]})
+	}
+	return samples
+}
+
+type histogramValue struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+type Histogram struct {
+	*vec[*histogramValue]
+	buckets []float64
+}
+
+func (h *Histogram) kind() Kind { return KindHistogram }
+
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.update(labelValues,
+		func() *histogramValue { return &histogramValue{counts: make([]uint64, len(h.buckets))} },
+		func(hv *histogramValue) *histogramValue {
+			if i, _ := slices.BinarySearch(h.buckets, value); i < len(h.buckets) {
+				hv.counts[i]++
+			}
+			hv.count++
+			hv.sum += value
+			return hv
+		})
+}
+
+func (h *Histogram) collect() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var samples []sample
+	for _, key := range h.keys {
+		hv := h.values[key]
+		pairs := h.pairs(key)
+
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += hv.counts[i]
+			samples = append(samples, sample{
+				suffix: "_bucket",
+				labels: append(slices.Clone(pairs), "le", formatValue(upper)),
+				value:  float64(cumulative),
+			})
+		}
+		samples = append(samples,
+			sample{suffix: "_bucket", labels: append(slices.Clone(pairs), "le", "+Inf"), value: float64(hv.count)},
+			sample{suffix: "_sum", labels: pairs, value: hv.sum},
+			sample{suffix: "_count", labels: pairs, value: float64(hv.count)},
+		)
+	}
+	return samples
+}
+
+type funcMetric struct {
+	name_  string
+	help_  string
+	kind_  Kind
+	labels []string
+	fn     func(emit func(value float64, labelValues ...string))
+}
+
+func (f *funcMetric) name() string { return f.name_ }
+func (f *funcMetric) help() string { return f.help_ }
+func (f *funcMetric) kind() Kind   { return f.kind_ }
+
+func (f *funcMetric) collect() []sample {
+	var samples []sample
+	f.fn(func(value float64, labelValues ...string) {
+		samples = append(samples, sample{labels: interleave(f.labels, labelValues), value: value})
+	})
+	return samples
+}
+
+func interleave(names, values []string) []string {
+	pairs := make([]string, 0, len(names)*2)
+	for i, name := range names {
+		pairs = append(pairs, name, values[i])
+	}
+	return pairs
+}
+
+func writeLabels(w *countingWriter, pairs []string) {
+	if len(pairs) == 0 {
+		return
+	}
+	w.WriteString("{")
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString(pairs[i] + `="` + escapeLabel(pairs[i+1]) + `"`)
+	}
+	w.WriteString("}")
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) {
+	c.Write([]byte(s))
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type memoryBucket struct {
+	Bucket
+	limit Limit
+}
+
+// MemoryStore keeps buckets of a single instance
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{Bucket: NewBucket(limit, now)}
+		s.buckets[key] = b
+	}
+	b.limit = limit
+
+	return b.Take(limit, now), nil
+}
+
+// drops refilled buckets, so idle clients do not hold memory
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.Full(b.limit, now) {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestMemoryStore() (*MemoryStore, *testClock) {
+	clock := &testClock{now: time.Unix(1_700_000_000, 0)}
+	s := NewMemoryStore()
+	s.now = clock.Now
+	s.lastSweep = clock.now
+	return s, clock
+}
+
+func take(t *testing.T, s *MemoryStore, key string, limit Limit) Result {
+	t.Helper()
+	res, err := s.Take(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestMemoryStoreBurstAndRefill(t *testing.T) {
+	s, clock := newTestMemoryStore()
+	limit := Limit{Requests: 5, Per: time.Minute}
+
+	for i := range 5 {
+		if res := take(t, s, "ip:1", limit); !res.Allowed {
+			t.Fatalf("take %d of the burst is denied", i+1)
+		}
+	}
+	res := take(t, s, "ip:1", limit)
+	if res.Allowed {
+		t.Fatal("take after the burst is allowed")
+	}
+	if res.RetryAfter != 12*time.Second {
+		t.Errorf("retry after = %v, want 12s", res.RetryAfter)
+	}
+
+	clock.Advance(11 * time.Second)
+	if res := take(t, s, "ip:1", limit); res.Allowed {
+		t.Fatal("take before a token is refilled is allowed")
+	}
+	clock.Advance(time.Second)
+	if res := take(t, s, "ip:1", limit); !res.Allowed {
+		t.Fatal("take after a token is refilled is denied")
+	}
+	if res := take(t, s, "ip:1", limit); res.Allowed {
+		t.Fatal("one refilled token allowed two takes")
+	}
+}
+
+func TestMemoryStoreKeysAreSeparate(t *testing.T) {
+	s, _ := newTestMemoryStore()
+	limit := Limit{Requests: 1, Per: time.Minute}
+
+	if res := take(t, s, "ip:1", limit); !res.Allowed {
+		t.Fatal("first take of ip:1 is denied")
+	}
+	if res := take(t, s, "ip:1", limit); res.Allowed {
+		t.Fatal("second take of ip:1 is allowed")
+	}
+	if res := take(t, s, "ip:2", limit); !res.Allowed {
+		t.Fatal("ip:2 shares the bucket of ip:1")
+	}
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	s, clock := newTestMemoryStore()
+	limit := Limit{Requests: 2, Per: time.Hour}
+
+	take(t, s, "idle", Limit{Requests: 2, Per: time.Second})
+	take(t, s, "busy", limit)
+
+	clock.Advance(sweepInterval + time.Second)
+	take(t, s, "other", limit)
+
+	if _, ok := s.buckets["idle"]; ok {
+		t.Error("refilled bucket is kept after the sweep")
+	}
+	if _, ok := s.buckets["busy"]; !ok {
+		t.Error("bucket with taken tokens is dropped by the sweep")
+	}
+	if !s.lastSweep.Equal(clock.now) {
+		t.Errorf("last sweep = %v, want %v", s.lastSweep, clock.now)
+	}
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidLimit = errors.New("invalid rate limit")
+
+// Limit allows Requests per Per period, bursts up to Requests are allowed.
+// Zero Limit means no limit.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Requests, l.Per)
+}
+
+// Rate is in tokens per second
+func (l Limit) Rate() float64 {
+	return float64(l.Requests) / l.Per.Seconds()
+}
+
+// ParseLimit parses limits like "10/m", "100/h" or "5/30s".
+// Empty string and "0" disable the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+
+	count, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("%w: %q", ErrInvalidLimit, s)
+	}
+
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests < 0 {
+		return Limit{}, fmt.Errorf("%w: %q", ErrInvalidLimit, s)
+	}
+
+	var per time.Duration
+	switch period {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		if per, err = time.ParseDuration(period); err != nil || per <= 0 {
+			return Limit{}, fmt.Errorf("%w: %q", ErrInvalidLimit, s)
+		}
+	}
+
+	return Limit{Requests: requests, Per: per}, nil
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// time until the next request is allowed, zero when allowed
+	RetryAfter time.Duration
+	// time until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps token buckets, Take consumes one token of the key's bucket
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Bucket is the state of one key, stores persist it between requests
+type Bucket struct {
+	Tokens    float64
+	UpdatedAt time.Time
+}
+
+func NewBucket(limit Limit, now time.Time) Bucket {
+	return Bucket{Tokens: float64(limit.Requests), UpdatedAt: now}
+}
+
+// Take refills the bucket for the time passed since the last update
+// and consumes a token when there is one.
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	if elapsed := now.Sub(b.UpdatedAt).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Requests), b.Tokens+elapsed*limit.Rate())
+		b.UpdatedAt = now
+	}
+
+	allowed := b.Tokens >= 1
+	if allowed {
+		b.Tokens--
+	}
+	return NewResult(limit, b.Tokens, allowed)
+}
+
+// NewResult describes a bucket with tokens left after a take
+func NewResult(limit Limit, tokens float64, allowed bool) Result {
+	rate := limit.Rate()
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(tokens),
+		Reset:     seconds((float64(limit.Requests) - tokens) / rate),
+	}
+	if !allowed {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return res
+}
+
+// Full reports whether the bucket would be refilled completely at now,
+// such buckets are equal to new ones and may be dropped.
+func (b *Bucket) Full(limit Limit, now time.Time) bool {
+	return b.Tokens+now.Sub(b.UpdatedAt).Seconds()*limit.Rate() >= float64(limit.Requests)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
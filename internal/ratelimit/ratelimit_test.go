@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Limit
+	}{
+		{"", Limit{}},
+		{"0", Limit{}},
+		{"10/s", Limit{Requests: 10, Per: time.Second}},
+		{" 10/m ", Limit{Requests: 10, Per: time.Minute}},
+		{"100/h", Limit{Requests: 100, Per: time.Hour}},
+		{"5/30s", Limit{Requests: 5, Per: 30 * time.Second}},
+		{"0/m", Limit{Per: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.in)
+		if err != nil {
+			t.Errorf("ParseLimit(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseLimit(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"10", "x/m", "-1/m", "10/d", "10/-5s", "10/0s"} {
+		if _, err := ParseLimit(in); !errors.Is(err, ErrInvalidLimit) {
+			t.Errorf("ParseLimit(%q) error = %v, want ErrInvalidLimit", in, err)
+		}
+	}
+}
+
+func TestBucketBurst(t *testing.T) {
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+	now := time.Unix(1_700_000_000, 0)
+	b := NewBucket(limit, now)
+
+	for i := range limit.Requests {
+		res := b.Take(limit, now)
+		if !res.Allowed {
+			t.Fatalf("take %d of the burst is denied", i+1)
+		}
+		if want := limit.Requests - 1 - i; res.Remaining != want {
+			t.Errorf("take %d: remaining = %d, want %d", i+1, res.Remaining, want)
+		}
+		if res.RetryAfter != 0 {
+			t.Errorf("take %d: retry after = %v, want 0", i+1, res.RetryAfter)
+		}
+	}
+
+	res := b.Take(limit, now)
+	if res.Allowed {
+		t.Fatal("take after the burst is allowed")
+	}
+	if res.Remaining != 0 || res.Limit != 3 {
+		t.Errorf("remaining = %d, limit = %d, want 0 and 3", res.Remaining, res.Limit)
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("retry after = %v, want a token period of 1s", res.RetryAfter)
+	}
+	if res.Reset != 3*time.Second {
+		t.Errorf("reset = %v, want 3s", res.Reset)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	limit := Limit{Requests: 2, Per: 2 * time.Second}
+	now := time.Unix(1_700_000_000, 0)
+	b := NewBucket(limit, now)
+	b.Take(limit, now)
+	b.Take(limit, now)
+
+	// half a token is not enough
+	res := b.Take(limit, now.Add(500*time.Millisecond))
+	if res.Allowed {
+		t.Fatal("take with half a token is allowed")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("retry after = %v, want 500ms", res.RetryAfter)
+	}
+
+	if res := b.Take(limit, now.Add(time.Second)); !res.Allowed {
+		t.Fatal("take after a token period is denied")
+	}
+	if res := b.Take(limit, now.Add(time.Second)); res.Allowed {
+		t.Fatal("second take after a token period is allowed")
+	}
+
+	// a long pause refills up to the burst, not more
+	later := now.Add(time.Hour)
+	if !b.Full(limit, later) {
+		t.Error("bucket is not full after an hour")
+	}
+	for i := range limit.Requests {
+		if res := b.Take(limit, later); !res.Allowed {
+			t.Fatalf("take %d after a pause is denied", i+1)
+		}
+	}
+	if res := b.Take(limit, later); res.Allowed {
+		t.Fatal("pause refilled more than the burst")
+	}
+}
+
+func TestBucketClockGoesBack(t *testing.T) {
+	limit := Limit{Requests: 1, Per: time.Second}
+	now := time.Unix(1_700_000_000, 0)
+	b := NewBucket(limit, now)
+	b.Take(limit, now)
+
+	if res := b.Take(limit, now.Add(-time.Hour)); res.Allowed {
+		t.Fatal("earlier time refilled the bucket")
+	}
+	if !b.UpdatedAt.Equal(now) {
+		t.Errorf("updated at = %v, want %v", b.UpdatedAt, now)
+	}
+}
+
+func TestNewResult(t *testing.T) {
+	limit := Limit{Requests: 10, Per: 10 * time.Second}
+
+	res := NewResult(limit, 4.5, true)
+	want := Result{Allowed: true, Limit: 10, Remaining: 4, Reset: 5500 * time.Millisecond}
+	if res != want {
+		t.Errorf("allowed result = %+v, want %+v", res, want)
+	}
+
+	res = NewResult(limit, 0.25, false)
+	want = Result{Limit: 10, RetryAfter: 750 * time.Millisecond, Reset: 9750 * time.Millisecond}
+	if res != want {
+		t.Errorf("denied result = %+v, want %+v", res, want)
+	}
+}
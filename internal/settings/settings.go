@@ -0,0 +1,414 @@
+package settings
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// flag and environment variable naming the yaml file, the file is optional
+	FileFlag = "config"
+	FileEnv  = "CONFIG_FILE"
+
+	redacted = "[redacted]"
+)
+
+// sources of a value, later ones win
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Value parses yaml scalars, environment variables and flags alike
+type Value = flag.Value
+
+type field struct {
+	key    string
+	env    string
+	usage  string
+	value  Value
+	redact func(string) string
+	source string
+}
+
+// Set binds configuration keys to variables.
+// A key like "db.max_open_conns" is the nested yaml path, the "-db.max_open_conns" flag
+// and, when env is not empty, an environment variable
+type Set struct {
+	name   string
+	fields []*field
+	keys   map[string]*field
+	file   string
+}
+
+func New(name string) *Set {
+	return &Set{name: name, keys: map[string]*field{}}
+}
+
+// Var binds value to key, value holds the default
+func (s *Set) Var(value Value, key, env, usage string) {
+	s.add(&field{key: key, env: env, usage: usage, value: value})
+}
+
+func (s *Set) add(f *field) {
+	if _, ok := s.keys[f.key]; ok {
+		panic("settings: duplicate key " + f.key)
+	}
+	f.source = SourceDefault
+	s.fields = append(s.fields, f)
+	s.keys[f.key] = f
+}
+
+func (s *Set) String(p *string, key, env, def, usage string) {
+	*p = def
+	s.Var((*stringValue)(p), key, env, usage)
+}
+
+// Secret is a string which is never printed
+func (s *Set) Secret(p *string, key, env, usage string) {
+	s.add(&field{key: key, env: env, usage: usage, value: (*stringValue)(p), redact: func(string) string {
+		return redacted
+	}})
+}
+
+// URL is a string whose password is never printed
+func (s *Set) URL(p *string, key, env, def, usage string) {
+	*p = def
+	s.add(&field{key: key, env: env, usage: usage, value: (*urlValue)(p), redact: func(v string) string {
+		u, err := url.Parse(v)
+		if err != nil {
+			return redacted
+		}
+		return u.Redacted()
+	}})
+}
+
+func (s *Set) Int(p *int, key, env string, def int, usage string) {
+	*p = def
+	s.Var((*intValue)(p), key, env, usage)
+}
+
+func (s *Set) Bool(p *bool, key, env string, def bool, usage string) {
+	*p = def
+	s.Var((*boolValue)(p), key, env, usage)
+}
+
+func (s *Set) Duration(p *time.Duration, key, env string, def time.Duration, usage string) {
+	*p = def
+	s.Var((*durationValue)(p), key, env, usage)
+}
+
+// Time is an RFC 3339 timestamp, zero when not set
+func (s *Set) Time(p *time.Time, key, env, usage string) {
+	s.Var((*timeValue)(p), key, env, usage)
+}
+
+// Strings is a comma separated list, yaml sequences are accepted too
+func (s *Set) Strings(p *[]string, key, env string, def []string, usage string) {
+	*p = def
+	s.Var((*stringsValue)(p), key, env, usage)
+}
+
+// Load applies the yaml file, environment variables and flags on top of the defaults.
+// Unknown file keys, unknown flags and values which do not parse are errors
+func (s *Set) Load(args []string) error {
+	fs := flag.NewFlagSet(s.name, flag.ContinueOnError)
+	fs.StringVar(&s.file, FileFlag, os.Getenv(FileEnv), "yaml config file, env "+FileEnv)
+
+	// flags are recorded and applied last, parsing would set them before the file
+	flags := make([]*flagValue, len(s.fields))
+	for i, f := range s.fields {
+		flags[i] = &flagValue{field: f}
+		usage := f.usage
+		if f.env != "" {
+			usage += ", env " + f.env
+		}
+		fs.Var(flags[i], f.key, usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+
+	if s.file != "" {
+		if err := s.loadFile(s.file); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range s.fields {
+		if f.env == "" {
+			continue
+		}
+		if v, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(v, SourceEnv); err != nil {
+				return fmt.Errorf("env %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, fv := range flags {
+		if fv.set {
+			if err := fv.field.set(fv.raw, SourceFlag); err != nil {
+				return fmt.Errorf("flag -%s: %w", fv.field.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// File is the loaded yaml file, empty when there is none
+func (s *Set) File() string {
+	return s.file
+}
+
+func (s *Set) loadFile(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	if err := s.applyNode(doc.Content[0], ""); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func (s *Set) applyNode(n *yaml.Node, prefix string) error {
+	if n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if err := s.applyNode(n.Content[i+1], key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, ok := s.keys[prefix]
+	if !ok {
+		return fmt.Errorf("line %d: unknown key %q", n.Line, prefix)
+	}
+
+	var v string
+	switch n.Kind {
+	case yaml.ScalarNode:
+		v = n.Value
+		if n.Tag == "!!null" {
+			v = ""
+		}
+	case yaml.SequenceNode:
+		items := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return fmt.Errorf("line %d: %s: list items must be scalars", item.Line, prefix)
+			}
+			items = append(items, item.Value)
+		}
+		v = strings.Join(items, ",")
+	default:
+		return fmt.Errorf("line %d: %s: unsupported value", n.Line, prefix)
+	}
+
+	if err := f.set(v, SourceFile); err != nil {
+		return fmt.Errorf("line %d: %s: %w", n.Line, prefix, err)
+	}
+	return nil
+}
+
+func (f *field) set(v, source string) error {
+	if err := f.value.Set(v); err != nil {
+		return err
+	}
+	f.source = source
+	return nil
+}
+
+// Setting is an effective value with secrets redacted
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+func (s *Set) Effective() []Setting {
+	res := make([]Setting, len(s.fields))
+	for i, f := range s.fields {
+		res[i] = Setting{Key: f.key, Value: f.display(), Source: f.source}
+	}
+	return res
+}
+
+// Change is a setting which differs between two loads, values are redacted
+type Change struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// Diff compares raw values, so changed secrets are reported too
+func Diff(old, new *Set) []Change {
+	var changes []Change
+	for _, f := range new.fields {
+		prev, ok := old.keys[f.key]
+		if !ok {
+			continue
+		}
+		if v := f.value.String(); v != prev.value.String() {
+			changes = append(changes, Change{Key: f.key, Old: prev.display(), New: f.display()})
+		}
+	}
+	return changes
+}
+
+func (f *field) display() string {
+	v := f.value.String()
+	if f.redact != nil && v != "" {
+		v = f.redact(v)
+	}
+	return v
+}
+
+type flagValue struct {
+	field *field
+	raw   string
+	set   bool
+}
+
+func (v *flagValue) Set(s string) error {
+	// reject bad values while parsing, so the error names the flag
+	if err := v.field.value.Set(s); err != nil {
+		return err
+	}
+	v.raw, v.set = s, true
+	return nil
+}
+
+func (v *flagValue) String() string {
+	if v == nil || v.field == nil {
+		return ""
+	}
+	return v.field.value.String()
+}
+
+func (v *flagValue) IsBoolFlag() bool {
+	_, ok := v.field.value.(*boolValue)
+	return ok
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type urlValue string
+
+func (v *urlValue) Set(s string) error {
+	if _, err := url.Parse(s); err != nil {
+		// the error message would contain the password
+		return errors.New("invalid url")
+	}
+	*v = urlValue(s)
+	return nil
+}
+
+func (v *urlValue) String() string { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*v = intValue(i)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+type timeValue time.Time
+
+func (v *timeValue) Set(s string) error {
+	if s = strings.TrimSpace(s); s == "" {
+		*v = timeValue{}
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return fmt.Errorf("invalid RFC 3339 time %q", s)
+	}
+	*v = timeValue(t)
+	return nil
+}
+
+func (v *timeValue) String() string {
+	if time.Time(*v).IsZero() {
+		return ""
+	}
+	return time.Time(*v).Format(time.RFC3339)
+}
+
+type stringsValue []string
+
+// empty items are skipped
+func (v *stringsValue) Set(s string) error {
+	var res []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+	*v = res
+	return nil
+}
+
+func (v *stringsValue) String() string { return strings.Join(*v, ",") }
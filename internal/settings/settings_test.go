@@ -0,0 +1,263 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	name     string
+	port     int
+	timeout  time.Duration
+	tags     []string
+	secret   string
+	database string
+}
+
+func newTestSet(c *testConfig) *Set {
+	s := New("test")
+	s.String(&c.name, "name", "TEST_NAME", "default", "name")
+	s.Int(&c.port, "server.port", "TEST_PORT", 80, "port")
+	s.Duration(&c.timeout, "server.timeout", "", time.Second, "timeout without env")
+	s.Strings(&c.tags, "tags", "TEST_TAGS", []string{"a"}, "tags")
+	s.Secret(&c.secret, "auth.secret", "TEST_SECRET", "secret")
+	s.URL(&c.database, "db.addr", "TEST_DB_ADDR", "postgres://user:pass@db/blog", "database")
+	return s
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func sources(s *Set) map[string]string {
+	res := map[string]string{}
+	for _, setting := range s.Effective() {
+		res[setting.Key] = setting.Source
+	}
+	return res
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "name: file\nserver:\n  port: 81\n  timeout: 2s\ntags: [b, c]\n")
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    string
+		source  string
+		port    int
+		timeout time.Duration
+	}{
+		{
+			name:    "default",
+			want:    "default",
+			source:  SourceDefault,
+			port:    80,
+			timeout: time.Second,
+		},
+		{
+			name:    "file over default",
+			args:    []string{"-config", file},
+			want:    "file",
+			source:  SourceFile,
+			port:    81,
+			timeout: 2 * time.Second,
+		},
+		{
+			name:    "env over file",
+			env:     map[string]string{"TEST_NAME": "env", "TEST_PORT": "82"},
+			args:    []string{"-config", file},
+			want:    "env",
+			source:  SourceEnv,
+			port:    82,
+			timeout: 2 * time.Second,
+		},
+		{
+			name:    "flag over env",
+			env:     map[string]string{"TEST_NAME": "env", "TEST_PORT": "82"},
+			args:    []string{"-config", file, "-name", "flag", "-server.timeout", "3s"},
+			want:    "flag",
+			source:  SourceFlag,
+			port:    82,
+			timeout: 3 * time.Second,
+		},
+		{
+			name:    "file from env",
+			env:     map[string]string{FileEnv: file},
+			want:    "file",
+			source:  SourceFile,
+			port:    81,
+			timeout: 2 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			var c testConfig
+			s := newTestSet(&c)
+			if err := s.Load(tt.args); err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+
+			if c.name != tt.want {
+				t.Errorf("name = %q, want %q", c.name, tt.want)
+			}
+			if got := sources(s)["name"]; got != tt.source {
+				t.Errorf("source = %q, want %q", got, tt.source)
+			}
+			if c.port != tt.port {
+				t.Errorf("port = %d, want %d", c.port, tt.port)
+			}
+			if c.timeout != tt.timeout {
+				t.Errorf("timeout = %v, want %v", c.timeout, tt.timeout)
+			}
+		})
+	}
+}
+
+func TestLoadFileList(t *testing.T) {
+	file := writeFile(t, "tags:\n  - b\n  - c\n")
+
+	var c testConfig
+	s := newTestSet(&c)
+	if err := s.Load([]string{"-config", file}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(c.tags, ","); got != "b,c" {
+		t.Errorf("tags = %q, want b,c", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{name: "unknown file key", file: "nmae: typo\n", want: `unknown key "nmae"`},
+		{name: "unknown nested key", file: "server:\n  prot: 1\n", want: `unknown key "server.prot"`},
+		{name: "invalid file value", file: "server:\n  port: abc\n", want: "invalid integer"},
+		{name: "nested list item", file: "tags:\n  - [a]\n", want: "list items must be scalars"},
+		{name: "unknown flag", args: []string{"-nmae", "x"}, want: "flag provided but not defined"},
+		{name: "invalid flag value", args: []string{"-server.port", "abc"}, want: "invalid integer"},
+		{name: "invalid env value", env: map[string]string{"TEST_PORT": "abc"}, want: "env TEST_PORT"},
+		{name: "positional argument", args: []string{"extra"}, want: "unexpected arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+
+			var c testConfig
+			s := newTestSet(&c)
+			err := s.Load(args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Load error = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEffectiveRedacts(t *testing.T) {
+	t.Setenv("TEST_SECRET", "hunter2")
+
+	var c testConfig
+	s := newTestSet(&c)
+	if err := s.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]string{}
+	for _, setting := range s.Effective() {
+		if strings.Contains(setting.Value, "hunter2") || strings.Contains(setting.Value, "pass") {
+			t.Errorf("%s = %q, leaks a secret", setting.Key, setting.Value)
+		}
+		values[setting.Key] = setting.Value
+	}
+	if values["auth.secret"] != redacted {
+		t.Errorf("auth.secret = %q, want %q", values["auth.secret"], redacted)
+	}
+	if want := "postgres://user:xxxxx@db/blog"; values["db.addr"] != want {
+		t.Errorf("db.addr = %q, want %q", values["db.addr"], want)
+	}
+	if c.secret != "hunter2" {
+		t.Errorf("secret = %q, redaction must not change the value", c.secret)
+	}
+}
+
+func TestDiffRedacts(t *testing.T) {
+	var oldConfig, newConfig testConfig
+	old, updated := newTestSet(&oldConfig), newTestSet(&newConfig)
+
+	t.Setenv("TEST_SECRET", "old-secret")
+	if err := old.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_SECRET", "new-secret")
+	t.Setenv("TEST_DB_ADDR", "postgres://user:other@db/blog")
+	t.Setenv("TEST_NAME", "renamed")
+	if err := updated.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	changes := map[string]Change{}
+	for _, c := range Diff(old, updated) {
+		if strings.Contains(c.Old+c.New, "secret") || strings.Contains(c.Old+c.New, "pass") || strings.Contains(c.Old+c.New, "other") {
+			t.Errorf("%s: %q -> %q, leaks a secret", c.Key, c.Old, c.New)
+		}
+		changes[c.Key] = c
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("changes = %v, want name, auth.secret and db.addr", changes)
+	}
+	if c := changes["auth.secret"]; c.Old != redacted || c.New != redacted {
+		t.Errorf("auth.secret change = %+v, want redacted values", c)
+	}
+	if c := changes["name"]; c.Old != "default" || c.New != "renamed" {
+		t.Errorf("name change = %+v", c)
+	}
+}
+
+func TestTime(t *testing.T) {
+	var at time.Time
+	s := New("test")
+	s.Time(&at, "retired_at", "", "time")
+
+	if err := s.Load(nil); err != nil || !at.IsZero() {
+		t.Fatalf("default = %v, %v, want the zero time", at, err)
+	}
+	if err := s.Load([]string{"-retired_at", "2025-10-01T12:00:00Z"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC); !at.Equal(want) {
+		t.Errorf("time = %v, want %v", at, want)
+	}
+
+	s = New("test")
+	s.Time(&at, "retired_at", "", "time")
+	if err := s.Load([]string{"-retired_at", "2025-10-01"}); err == nil {
+		t.Error("a date without time is accepted")
+	}
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// Cache keeps users and articles by id in memory
+type Cache struct {
+	users    *lru[store.User]
+	articles *lru[store.Article]
+}
+
+// New creates a cache of up to size users and size articles, each kept for ttl
+func New(size int, ttl time.Duration) *Cache {
+	return &Cache{
+		users:    newLRU[store.User](size, ttl),
+		articles: newLRU[store.Article](size, ttl),
+	}
+}
+
+// Wrap returns storage which reads users and articles by id through the cache
+// and invalidates them on writes. Other stores are used as is.
+func (c *Cache) Wrap(s store.Storage) store.Storage {
+	wrapped := s
+	wrapped.Users = &userStore{s, c}
+	wrapped.Articles = &articleStore{s, c}
+	wrapped.TwoFactor = &twoFactorStore{s, c}
+	return wrapped
+}
+
+func (c *Cache) InvalidateUser(id int) {
+	c.users.invalidate(id)
+}
+
+func (c *Cache) InvalidateArticle(id int) {
+	c.articles.invalidate(id)
+}
+
+// Purge drops all entries
+func (c *Cache) Purge() {
+	c.users.purge()
+	c.articles.purge()
+}
+
+func (c *Cache) Stats() map[string]Stats {
+	return map[string]Stats{
+		"users":    c.users.getStats(),
+		"articles": c.articles.getStats(),
+	}
+}
+
+type userStore struct {
+	next  store.Storage
+	cache *Cache
+}
+
+func (s *userStore) GetByID(ctx context.Context, id int) (*store.User, error) {
+	return s.cache.users.get(ctx, id, s.next.Users.GetByID)
+}
+
+// a miss of any id would be a query of its own, the batch goes to the database
+func (s *userStore) GetByIDs(ctx context.Context, ids []int) (map[int]*store.User, error) {
+	return s.next.Users.GetByIDs(ctx, ids)
+}
+
+func (s *userStore) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	return s.next.Users.GetByEmail(ctx, email)
+}
+
+func (s *userStore) Create(ctx context.Context, user *store.User) error {
+	return s.next.Users.Create(ctx, user)
+}
+
+type articleStore struct {
+	next  store.Storage
+	cache *Cache
+}
+
+func (s *articleStore) GetLastTen(ctx context.Context) ([]*store.LatestArticle, error) {
+	return s.next.Articles.GetLastTen(ctx)
+}
+
+func (s *articleStore) GetByID(ctx context.Context, id int) (*store.Article, error) {
+	return s.cache.articles.get(ctx, id, s.next.Articles.GetByID)
+}
+
+func (s *articleStore) GetByAuthor(ctx context.Context, userID int, pq store.PaginatedQuery, withDrafts bool) ([]*store.Article, error) {
+	return s.next.Articles.GetByAuthor(ctx, userID, pq, withDrafts)
+}
+
+func (s *articleStore) CountByAuthor(ctx context.Context, userID int, withDrafts bool) (int, error) {
+	return s.next.Articles.CountByAuthor(ctx, userID, withDrafts)
+}
+
+func (s *articleStore) Count(ctx context.Context, filter store.ArticleFilter) (int, error) {
+	return s.next.Articles.Count(ctx, filter)
+}
+
+func (s *articleStore) List(ctx context.Context, filter store.ArticleFilter, pq store.PaginatedQuery) ([]*store.Article, error) {
+	return s.next.Articles.List(ctx, filter, pq)
+}
+
+// tags are not part of the cached article
+func (s *articleStore) GetTags(ctx context.Context, articleIDs []int) (map[int][]string, error) {
+	return s.next.Articles.GetTags(ctx, articleIDs)
+}
+
+func (s *articleStore) Create(ctx context.Context, article *store.Article) (int, error) {
+	return s.next.Articles.Create(ctx, article)
+}
+
+func (s *articleStore) Update(ctx context.Context, article *store.Article) (int, error) {
+	defer s.cache.InvalidateArticle(article.ID)
+	return s.next.Articles.Update(ctx, article)
+}
+
+func (s *articleStore) Delete(ctx context.Context, id, version int) error {
+	defer s.cache.InvalidateArticle(id)
+	return s.next.Articles.Delete(ctx, id, version)
+}
+
+func (s *articleStore) GetComments(ctx context.Context, articleID int, pq store.PaginatedQuery) ([]*store.Comment, error) {
+	return s.next.Articles.GetComments(ctx, articleID, pq)
+}
+
+func (s *articleStore) GetLatestComments(ctx context.Context, articleIDs []int, perArticle int) (map[int][]*store.Comment, error) {
+	return s.next.Articles.GetLatestComments(ctx, articleIDs, perArticle)
+}
+
+// the comment count of the article changes
+func (s *articleStore) AddComment(ctx context.Context, comment *store.Comment) (int, error) {
+	defer s.cache.InvalidateArticle(comment.ArticleID)
+	return s.next.Articles.AddComment(ctx, comment)
+}
+
+func (s *articleStore) AddLike(ctx context.Context, articleID, userID int) error {
+	defer s.cache.InvalidateArticle(articleID)
+	return s.next.Articles.AddLike(ctx, articleID, userID)
+}
+
+// bookmarks are not part of the cached article
+func (s *articleStore) AddBookmark(ctx context.Context, articleID, userID int) error {
+	return s.next.Articles.AddBookmark(ctx, articleID, userID)
+}
+
+func (s *articleStore) DeleteBookmark(ctx context.Context, articleID, userID int) error {
+	return s.next.Articles.DeleteBookmark(ctx, articleID, userID)
+}
+
+func (s *articleStore) GetViewerStates(ctx context.Context, userID int, articleIDs []int) (map[int]store.ArticleViewerState, error) {
+	return s.next.Articles.GetViewerStates(ctx, userID, articleIDs)
+}
+
+// two-factor settings are part of the cached user
+type twoFactorStore struct {
+	next  store.Storage
+	cache *Cache
+}
+
+func (s *twoFactorStore) SetSecret(ctx context.Context, userID int, secret string) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.SetSecret(ctx, userID, secret)
+}
+
+func (s *twoFactorStore) ReplaceSecret(ctx context.Context, userID int, old, secret string) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.ReplaceSecret(ctx, userID, old, secret)
+}
+
+func (s *twoFactorStore) Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.Enable(ctx, userID, step, recoveryCodeHashes)
+}
+
+func (s *twoFactorStore) Disable(ctx context.Context, userID int) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.Disable(ctx, userID)
+}
+
+func (s *twoFactorStore) SetRequired(ctx context.Context, userID int, required bool) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.SetRequired(ctx, userID, required)
+}
+
+func (s *twoFactorStore) UseStep(ctx context.Context, userID int, step int64) error {
+	defer s.cache.InvalidateUser(userID)
+	return s.next.TwoFactor.UseStep(ctx, userID, step)
+}
+
+func (s *twoFactorStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	return s.next.TwoFactor.UseRecoveryCode(ctx, userID, codeHash)
+}
+
+func (s *twoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	return s.next.TwoFactor.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+}
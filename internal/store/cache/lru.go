@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type Stats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Size      int    `json:"size"`
+}
+
+type entry[V any] struct {
+	id        int
+	value     V
+	expiresAt time.Time
+}
+
+// lru keeps up to size values by id for ttl.
+// Concurrent misses of one id share a single load.
+type lru[V any] struct {
+	size int
+	ttl  time.Duration
+
+	mu    sync.Mutex
+	items map[int]*list.Element
+	order *list.List
+	// bumped by every invalidation, loads started before it are not stored
+	gen   uint64
+	stats Stats
+
+	group singleflight.Group
+}
+
+func newLRU[V any](size int, ttl time.Duration) *lru[V] {
+	return &lru[V]{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[int]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns a copy of the cached value, so callers may modify it
+func (c *lru[V]) get(ctx context.Context, id int, load func(context.Context, int) (*V, error)) (*V, error) {
+	v, gen, ok := c.lookup(id)
+	if ok {
+		return &v, nil
+	}
+
+	// callers after an invalidation do not join loads started before it
+	key := strconv.FormatUint(gen, 10) + ":" + strconv.Itoa(id)
+	res, err, _ := c.group.Do(key, func() (any, error) {
+		// the load is shared, one canceled caller must not fail the others
+		v, err := load(context.WithoutCancel(ctx), id)
+		if err != nil {
+			return nil, err
+		}
+
+		c.store(id, *v, gen)
+		return *v, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	v = res.(V)
+	return &v, nil
+}
+
+func (c *lru[V]) lookup(id int) (V, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	el, ok := c.items[id]
+	if !ok {
+		c.stats.Misses++
+		return zero, c.gen, false
+	}
+
+	e := el.Value.(*entry[V])
+	if time.Now().After(e.expiresAt) {
+		c.remove(el)
+		c.stats.Misses++
+		return zero, c.gen, false
+	}
+
+	c.order.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, c.gen, true
+}
+
+func (c *lru[V]) store(id int, v V, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if el, ok := c.items[id]; ok {
+		c.remove(el)
+	}
+	c.items[id] = c.order.PushFront(&entry[V]{id: id, value: v, expiresAt: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *lru[V]) invalidate(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.items[id]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *lru[V]) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.items = make(map[int]*list.Element)
+	c.order.Init()
+}
+
+func (c *lru[V]) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry[V]).id)
+}
+
+func (c *lru[V]) getStats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.order.Len()
+	return stats
+}
@@ -0,0 +1,376 @@
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// Observer is called before every store method, done is called with its error.
+// The returned context is passed to the method.
+type Observer func(ctx context.Context, store, method string) (_ context.Context, done func(error))
+
+// Wrap returns storage which reports every call to observe
+func Wrap(s store.Storage, observe Observer) store.Storage {
+	return store.Storage{
+		Users:         &userStore{s, observe},
+		Articles:      &articleStore{s, observe},
+		TwoFactor:     &twoFactorStore{s, observe},
+		Passkeys:      &passkeyStore{s, observe},
+		Sessions:      &sessionStore{s, observe},
+		LoginAttempts: &loginAttemptStore{s, observe},
+	}
+}
+
+type userStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *userStore) GetByID(ctx context.Context, id int) (*store.User, error) {
+	ctx, done := s.observe(ctx, "users", "GetByID")
+	res, err := s.next.Users.GetByID(ctx, id)
+	done(err)
+	return res, err
+}
+
+func (s *userStore) GetByIDs(ctx context.Context, ids []int) (map[int]*store.User, error) {
+	ctx, done := s.observe(ctx, "users", "GetByIDs")
+	res, err := s.next.Users.GetByIDs(ctx, ids)
+	done(err)
+	return res, err
+}
+
+func (s *userStore) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	ctx, done := s.observe(ctx, "users", "GetByEmail")
+	res, err := s.next.Users.GetByEmail(ctx, email)
+	done(err)
+	return res, err
+}
+
+func (s *userStore) Create(ctx context.Context, user *store.User) error {
+	ctx, done := s.observe(ctx, "users", "Create")
+	err := s.next.Users.Create(ctx, user)
+	done(err)
+	return err
+}
+
+type articleStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *articleStore) GetLastTen(ctx context.Context) ([]*store.LatestArticle, error) {
+	ctx, done := s.observe(ctx, "articles", "GetLastTen")
+	res, err := s.next.Articles.GetLastTen(ctx)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) GetByID(ctx context.Context, id int) (*store.Article, error) {
+	ctx, done := s.observe(ctx, "articles", "GetByID")
+	res, err := s.next.Articles.GetByID(ctx, id)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) GetByAuthor(ctx context.Context, userID int, pq store.PaginatedQuery, withDrafts bool) ([]*store.Article, error) {
+	ctx, done := s.observe(ctx, "articles", "GetByAuthor")
+	res, err := s.next.Articles.GetByAuthor(ctx, userID, pq, withDrafts)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) CountByAuthor(ctx context.Context, userID int, withDrafts bool) (int, error) {
+	ctx, done := s.observe(ctx, "articles", "CountByAuthor")
+	res, err := s.next.Articles.CountByAuthor(ctx, userID, withDrafts)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) Count(ctx context.Context, filter store.ArticleFilter) (int, error) {
+	ctx, done := s.observe(ctx, "articles", "Count")
+	res, err := s.next.Articles.Count(ctx, filter)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) List(ctx context.Context, filter store.ArticleFilter, pq store.PaginatedQuery) ([]*store.Article, error) {
+	ctx, done := s.observe(ctx, "articles", "List")
+	res, err := s.next.Articles.List(ctx, filter, pq)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) GetTags(ctx context.Context, articleIDs []int) (map[int][]string, error) {
+	ctx, done := s.observe(ctx, "articles", "GetTags")
+	res, err := s.next.Articles.GetTags(ctx, articleIDs)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) Create(ctx context.Context, article *store.Article) (int, error) {
+	ctx, done := s.observe(ctx, "articles", "Create")
+	res, err := s.next.Articles.Create(ctx, article)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) Update(ctx context.Context, article *store.Article) (int, error) {
+	ctx, done := s.observe(ctx, "articles", "Update")
+	res, err := s.next.Articles.Update(ctx, article)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) Delete(ctx context.Context, id, version int) error {
+	ctx, done := s.observe(ctx, "articles", "Delete")
+	err := s.next.Articles.Delete(ctx, id, version)
+	done(err)
+	return err
+}
+
+func (s *articleStore) GetComments(ctx context.Context, articleID int, pq store.PaginatedQuery) ([]*store.Comment, error) {
+	ctx, done := s.observe(ctx, "articles", "GetComments")
+	res, err := s.next.Articles.GetComments(ctx, articleID, pq)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) GetLatestComments(ctx context.Context, articleIDs []int, perArticle int) (map[int][]*store.Comment, error) {
+	ctx, done := s.observe(ctx, "articles", "GetLatestComments")
+	res, err := s.next.Articles.GetLatestComments(ctx, articleIDs, perArticle)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) AddComment(ctx context.Context, comment *store.Comment) (int, error) {
+	ctx, done := s.observe(ctx, "articles", "AddComment")
+	res, err := s.next.Articles.AddComment(ctx, comment)
+	done(err)
+	return res, err
+}
+
+func (s *articleStore) AddLike(ctx context.Context, articleID, userID int) error {
+	ctx, done := s.observe(ctx, "articles", "AddLike")
+	err := s.next.Articles.AddLike(ctx, articleID, userID)
+	done(err)
+	return err
+}
+
+func (s *articleStore) AddBookmark(ctx context.Context, articleID, userID int) error {
+	ctx, done := s.observe(ctx, "articles", "AddBookmark")
+	err := s.next.Articles.AddBookmark(ctx, articleID, userID)
+	done(err)
+	return err
+}
+
+func (s *articleStore) DeleteBookmark(ctx context.Context, articleID, userID int) error {
+	ctx, done := s.observe(ctx, "articles", "DeleteBookmark")
+	err := s.next.Articles.DeleteBookmark(ctx, articleID, userID)
+	done(err)
+	return err
+}
+
+func (s *articleStore) GetViewerStates(ctx context.Context, userID int, articleIDs []int) (map[int]store.ArticleViewerState, error) {
+	ctx, done := s.observe(ctx, "articles", "GetViewerStates")
+	res, err := s.next.Articles.GetViewerStates(ctx, userID, articleIDs)
+	done(err)
+	return res, err
+}
+
+type twoFactorStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *twoFactorStore) SetSecret(ctx context.Context, userID int, secret string) error {
+	ctx, done := s.observe(ctx, "two_factor", "SetSecret")
+	err := s.next.TwoFactor.SetSecret(ctx, userID, secret)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) ReplaceSecret(ctx context.Context, userID int, old, secret string) error {
+	ctx, done := s.observe(ctx, "two_factor", "ReplaceSecret")
+	err := s.next.TwoFactor.ReplaceSecret(ctx, userID, old, secret)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	ctx, done := s.observe(ctx, "two_factor", "Enable")
+	err := s.next.TwoFactor.Enable(ctx, userID, step, recoveryCodeHashes)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) Disable(ctx context.Context, userID int) error {
+	ctx, done := s.observe(ctx, "two_factor", "Disable")
+	err := s.next.TwoFactor.Disable(ctx, userID)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) SetRequired(ctx context.Context, userID int, required bool) error {
+	ctx, done := s.observe(ctx, "two_factor", "SetRequired")
+	err := s.next.TwoFactor.SetRequired(ctx, userID, required)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) UseStep(ctx context.Context, userID int, step int64) error {
+	ctx, done := s.observe(ctx, "two_factor", "UseStep")
+	err := s.next.TwoFactor.UseStep(ctx, userID, step)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	ctx, done := s.observe(ctx, "two_factor", "UseRecoveryCode")
+	err := s.next.TwoFactor.UseRecoveryCode(ctx, userID, codeHash)
+	done(err)
+	return err
+}
+
+func (s *twoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	ctx, done := s.observe(ctx, "two_factor", "ReplaceRecoveryCodes")
+	err := s.next.TwoFactor.ReplaceRecoveryCodes(ctx, userID, codeHashes)
+	done(err)
+	return err
+}
+
+type passkeyStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *passkeyStore) CreateChallenge(ctx context.Context, challenge *store.WebAuthnChallenge) error {
+	ctx, done := s.observe(ctx, "passkeys", "CreateChallenge")
+	err := s.next.Passkeys.CreateChallenge(ctx, challenge)
+	done(err)
+	return err
+}
+
+func (s *passkeyStore) ConsumeChallenge(ctx context.Context, challenge []byte, kind string) (*store.WebAuthnChallenge, error) {
+	ctx, done := s.observe(ctx, "passkeys", "ConsumeChallenge")
+	res, err := s.next.Passkeys.ConsumeChallenge(ctx, challenge, kind)
+	done(err)
+	return res, err
+}
+
+func (s *passkeyStore) Create(ctx context.Context, passkey *store.Passkey) error {
+	ctx, done := s.observe(ctx, "passkeys", "Create")
+	err := s.next.Passkeys.Create(ctx, passkey)
+	done(err)
+	return err
+}
+
+func (s *passkeyStore) GetByCredentialID(ctx context.Context, credentialID []byte) (*store.Passkey, error) {
+	ctx, done := s.observe(ctx, "passkeys", "GetByCredentialID")
+	res, err := s.next.Passkeys.GetByCredentialID(ctx, credentialID)
+	done(err)
+	return res, err
+}
+
+func (s *passkeyStore) GetByUser(ctx context.Context, userID int) ([]*store.Passkey, error) {
+	ctx, done := s.observe(ctx, "passkeys", "GetByUser")
+	res, err := s.next.Passkeys.GetByUser(ctx, userID)
+	done(err)
+	return res, err
+}
+
+func (s *passkeyStore) UpdateSignCount(ctx context.Context, id int, signCount uint32) error {
+	ctx, done := s.observe(ctx, "passkeys", "UpdateSignCount")
+	err := s.next.Passkeys.UpdateSignCount(ctx, id, signCount)
+	done(err)
+	return err
+}
+
+func (s *passkeyStore) Delete(ctx context.Context, id, userID int) error {
+	ctx, done := s.observe(ctx, "passkeys", "Delete")
+	err := s.next.Passkeys.Delete(ctx, id, userID)
+	done(err)
+	return err
+}
+
+type sessionStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *sessionStore) Create(ctx context.Context, session *store.Session) error {
+	ctx, done := s.observe(ctx, "sessions", "Create")
+	err := s.next.Sessions.Create(ctx, session)
+	done(err)
+	return err
+}
+
+func (s *sessionStore) GetByTokenHash(ctx context.Context, tokenHash string) (*store.Session, error) {
+	ctx, done := s.observe(ctx, "sessions", "GetByTokenHash")
+	res, err := s.next.Sessions.GetByTokenHash(ctx, tokenHash)
+	done(err)
+	return res, err
+}
+
+func (s *sessionStore) GetByUser(ctx context.Context, userID int) ([]*store.Session, error) {
+	ctx, done := s.observe(ctx, "sessions", "GetByUser")
+	res, err := s.next.Sessions.GetByUser(ctx, userID)
+	done(err)
+	return res, err
+}
+
+func (s *sessionStore) Touch(ctx context.Context, id int) error {
+	ctx, done := s.observe(ctx, "sessions", "Touch")
+	err := s.next.Sessions.Touch(ctx, id)
+	done(err)
+	return err
+}
+
+func (s *sessionStore) Delete(ctx context.Context, id, userID int) error {
+	ctx, done := s.observe(ctx, "sessions", "Delete")
+	err := s.next.Sessions.Delete(ctx, id, userID)
+	done(err)
+	return err
+}
+
+func (s *sessionStore) DeleteOthers(ctx context.Context, userID, keepID int) error {
+	ctx, done := s.observe(ctx, "sessions", "DeleteOthers")
+	err := s.next.Sessions.DeleteOthers(ctx, userID, keepID)
+	done(err)
+	return err
+}
+
+type loginAttemptStore struct {
+	next    store.Storage
+	observe Observer
+}
+
+func (s *loginAttemptStore) Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(*store.LoginAttempt) bool) (*store.LoginAttempt, error) {
+	ctx, done := s.observe(ctx, "login_attempts", "Reserve")
+	res, err := s.next.LoginAttempts.Reserve(ctx, key, resetAfter, allow)
+	done(err)
+	return res, err
+}
+
+func (s *loginAttemptStore) Release(ctx context.Context, key string) error {
+	ctx, done := s.observe(ctx, "login_attempts", "Release")
+	err := s.next.LoginAttempts.Release(ctx, key)
+	done(err)
+	return err
+}
+
+func (s *loginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	ctx, done := s.observe(ctx, "login_attempts", "Lock")
+	err := s.next.LoginAttempts.Lock(ctx, key, until)
+	done(err)
+	return err
+}
+
+func (s *loginAttemptStore) Reset(ctx context.Context, key string) error {
+	ctx, done := s.observe(ctx, "login_attempts", "Reset")
+	err := s.next.LoginAttempts.Reset(ctx, key)
+	done(err)
+	return err
+}
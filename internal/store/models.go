@@ -8,12 +8,37 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	ID        int      `json:"id"`
 	Username  string   `json:"username"`
 	Email     string   `json:"email"`
 	Password  password `json:"-"`
+	Role      string   `json:"role"`
 	CreatedAt string   `json:"created_at,omitempty"`
+
+	TwoFactor TwoFactor `json:"-"`
+}
+
+// Author is the public part of a user shown with articles,
+// the email is only set for the author and admins
+type Author struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+}
+
+type TwoFactor struct {
+	// base32 TOTP secret, set on enrollment before confirmation
+	Secret   string
+	Enabled  bool
+	Required bool
+	LastStep int64
 }
 
 type password struct {
@@ -37,6 +62,11 @@ func (p *password) CompareWithHash(text string) error {
 	return bcrypt.CompareHashAndPassword(p.Hash, []byte(text))
 }
 
+const (
+	ArticleDraft     = "draft"
+	ArticlePublished = "published"
+)
+
 type Article struct {
 	ID          int       `json:"id"`
 	Title       string    `json:"title"`
@@ -45,8 +75,55 @@ type Article struct {
 	Likes       int       `json:"likes"`
 	PublishedAt time.Time `json:"published_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	// incremented on every edit, likes and comments are counted without a new version
+	Version int `json:"version"`
+	// drafts are only visible to the author
+	Status        string `json:"status"`
+	CommentsCount int    `json:"comments_count"`
+	// written by Create and by Update when not nil, read with GetTags
+	Tags []string `json:"tags,omitempty"`
+
+	// related resources of ?include
+	Author   *Author    `json:"author,omitempty"`
+	Comments []*Comment `json:"comments,omitempty"`
+
+	// fields of the authenticated viewer, omitted for anonymous requests
+	LikedByMe      *bool `json:"liked_by_me,omitempty"`
+	BookmarkedByMe *bool `json:"bookmarked_by_me,omitempty"`
+	CanEdit        *bool `json:"can_edit,omitempty"`
+}
 
-	User User `json:"user"`
+// ArticleViewerState is what a user did to an article
+type ArticleViewerState struct {
+	Liked      bool
+	Bookmarked bool
+}
+
+// sort fields of article listings
+const (
+	SortPublishedAt = "published_at"
+	SortLikes       = "likes"
+	SortComments    = "comments"
+	SortUpdatedAt   = "updated_at"
+)
+
+var ArticleSortFields = []string{SortPublishedAt, SortLikes, SortComments, SortUpdatedAt}
+
+type ArticleSort struct {
+	Field string
+	Desc  bool
+}
+
+// ArticleFilter narrows and orders article listings, zero fields do not filter
+type ArticleFilter struct {
+	// newest first when empty
+	Sort            []ArticleSort
+	PublishedAfter  time.Time
+	PublishedBefore time.Time
+	AuthorID        int
+	// articles having all of them
+	Tags   []string
+	Status string
 }
 
 type LatestArticle struct {
@@ -98,3 +175,50 @@ type Comment struct {
 	Text      string `json:"text"`
 	CreatedAt string `json:"created_at"`
 }
+
+type Passkey struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	CredentialID []byte     `json:"-"`
+	PublicKey    []byte     `json:"-"`
+	Algorithm    int        `json:"algorithm"`
+	SignCount    uint32     `json:"-"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+const (
+	CeremonyRegister = "register"
+	CeremonyLogin    = "login"
+)
+
+// WebAuthnChallenge is a pending registration or login ceremony
+type WebAuthnChallenge struct {
+	Challenge []byte
+	Kind      string
+	// zero for login without known user
+	UserID    int
+	ExpiresAt time.Time
+}
+
+// Session is a cookie login of a browser, the token is stored hashed
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	TokenHash  string    `json:"-"`
+	CSRFToken  string    `json:"-"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LoginAttempt counts failed logins of an account or ip key
+type LoginAttempt struct {
+	Key         string
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
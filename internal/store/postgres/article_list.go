@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// the only columns listings can be ordered by, values of the request never reach the sql text
+var articleSortColumns = map[string]string{
+	store.SortPublishedAt: "articles.published_at",
+	store.SortLikes:       "articles.likes",
+	store.SortComments:    "articles.comments_count",
+	store.SortUpdatedAt:   "articles.updated_at",
+}
+
+// articleListQuery builds the listing query of filter, every value is a parameter.
+// Orders match the (status, <column> DESC, id DESC) indexes of migrations/0004_article_listing.sql.
+func articleListQuery(filter store.ArticleFilter, page store.PaginatedQuery) (string, []any, error) {
+	where, args := articleListWhere(filter)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	sorts := filter.Sort
+	if len(sorts) == 0 {
+		sorts = []store.ArticleSort{{Field: store.SortPublishedAt, Desc: true}}
+	}
+	order := make([]string, 0, len(sorts)+1)
+	for _, s := range sorts {
+		column, ok := articleSortColumns[s.Field]
+		if !ok {
+			return "", nil, fmt.Errorf("unknown sort field %q", s.Field)
+		}
+		order = append(order, column+direction(s.Desc))
+	}
+	// ties are ordered by id, so pages do not overlap
+	order = append(order, "articles.id"+direction(sorts[0].Desc))
+
+	query := `
+		SELECT ` + articleColumns + `
+		FROM articles
+		WHERE ` + where + `
+		ORDER BY ` + strings.Join(order, ", ") + `
+		LIMIT ` + arg(page.Limit) + ` OFFSET ` + arg(page.Offset)
+
+	return query, args, nil
+}
+
+func articleCountQuery(filter store.ArticleFilter) (string, []any) {
+	where, args := articleListWhere(filter)
+	return `SELECT count(*) FROM articles WHERE ` + where, args
+}
+
+// conditions of filter joined by AND, with their parameters
+func articleListWhere(filter store.ArticleFilter) (string, []any) {
+	var (
+		where []string
+		args  []any
+	)
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	status := filter.Status
+	if status == "" {
+		status = store.ArticlePublished
+	}
+	where = append(where, "articles.status = "+arg(status))
+
+	if filter.AuthorID != 0 {
+		where = append(where, "articles.author_id = "+arg(filter.AuthorID))
+	}
+	// published_at is without time zone and written in utc
+	if !filter.PublishedAfter.IsZero() {
+		where = append(where, "articles.published_at > "+arg(filter.PublishedAfter.UTC()))
+	}
+	if !filter.PublishedBefore.IsZero() {
+		where = append(where, "articles.published_at < "+arg(filter.PublishedBefore.UTC()))
+	}
+	if len(filter.Tags) > 0 {
+		where = append(where, `articles.id IN (
+			SELECT article_id FROM article_tags WHERE tag = ANY(`+arg(pq.Array(filter.Tags))+`)
+			GROUP BY article_id HAVING count(*) = `+arg(len(filter.Tags))+`
+		)`)
+	}
+
+	return strings.Join(where, " AND "), args
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
@@ -0,0 +1,167 @@
+package postgres
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// orderBy cuts the ORDER BY clause out of query
+func orderBy(t *testing.T, query string) string {
+	t.Helper()
+	_, order, ok := strings.Cut(query, "ORDER BY ")
+	if !ok {
+		t.Fatalf("no ORDER BY in %s", query)
+	}
+	order, _, _ = strings.Cut(order, "\n")
+	return strings.TrimSpace(order)
+}
+
+func TestArticleListQuerySort(t *testing.T) {
+	page := store.PaginatedQuery{Limit: 10}
+
+	tests := []struct {
+		name string
+		sort []store.ArticleSort
+		want string
+	}{
+		{
+			name: "newest first by default",
+			want: "articles.published_at DESC, articles.id DESC",
+		},
+		{
+			name: "ascending",
+			sort: []store.ArticleSort{{Field: store.SortPublishedAt}},
+			want: "articles.published_at ASC, articles.id ASC",
+		},
+		{
+			name: "ties follow the first field",
+			sort: []store.ArticleSort{{Field: store.SortLikes, Desc: true}, {Field: store.SortUpdatedAt}},
+			want: "articles.likes DESC, articles.updated_at ASC, articles.id DESC",
+		},
+		{
+			name: "comments",
+			sort: []store.ArticleSort{{Field: store.SortComments}},
+			want: "articles.comments_count ASC, articles.id ASC",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _, err := articleListQuery(store.ArticleFilter{Sort: tt.sort}, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := orderBy(t, query); got != tt.want {
+				t.Errorf("ORDER BY %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArticleListQueryUnknownSort(t *testing.T) {
+	for _, field := range []string{"title", "articles.id; DROP TABLE articles", ""} {
+		sort := []store.ArticleSort{{Field: store.SortLikes}, {Field: field}}
+		query, args, err := articleListQuery(store.ArticleFilter{Sort: sort}, store.PaginatedQuery{Limit: 10})
+		if err == nil || !strings.Contains(err.Error(), "unknown sort field") {
+			t.Errorf("sort by %q: error = %v, want unknown sort field", field, err)
+		}
+		if query != "" || args != nil {
+			t.Errorf("sort by %q: query = %q, args = %v, want none", field, query, args)
+		}
+	}
+}
+
+func TestArticleListQueryTags(t *testing.T) {
+	tags := []string{"go", "sql'); DROP TABLE articles; --"}
+	query, args, err := articleListQuery(store.ArticleFilter{Tags: tags}, store.PaginatedQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tag := range tags {
+		if strings.Contains(query, tag) {
+			t.Errorf("tag %q is spliced into the query", tag)
+		}
+	}
+	if !strings.Contains(query, "tag = ANY($2)") || !strings.Contains(query, "count(*) = $3") {
+		t.Errorf("tags are not parameters: %s", query)
+	}
+	want := []any{store.ArticlePublished, pq.Array(tags), len(tags), 10, 0}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestArticleListQueryArgs(t *testing.T) {
+	after := time.Date(2025, 1, 1, 0, 0, 0, 0, time.FixedZone("MSK", 3*60*60))
+	before := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	tags := []string{"go"}
+	page := store.PaginatedQuery{Limit: 5, Offset: 20}
+
+	tests := []struct {
+		name      string
+		filter    store.ArticleFilter
+		wantWhere []string
+		wantArgs  []any
+	}{
+		{
+			name:      "status only",
+			filter:    store.ArticleFilter{Status: store.ArticleDraft},
+			wantWhere: []string{"articles.status = $1"},
+			wantArgs:  []any{store.ArticleDraft},
+		},
+		{
+			name:      "author and dates",
+			filter:    store.ArticleFilter{AuthorID: 7, PublishedAfter: after, PublishedBefore: before},
+			wantWhere: []string{"articles.status = $1", "articles.author_id = $2", "articles.published_at > $3", "articles.published_at < $4"},
+			// dates are compared in utc
+			wantArgs: []any{store.ArticlePublished, 7, after.UTC(), before},
+		},
+		{
+			name:      "all filters",
+			filter:    store.ArticleFilter{AuthorID: 7, PublishedBefore: before, Tags: tags, Status: store.ArticleDraft},
+			wantWhere: []string{"articles.status = $1", "articles.author_id = $2", "articles.published_at < $3", "tag = ANY($4)", "count(*) = $5"},
+			wantArgs:  []any{store.ArticleDraft, 7, before, pq.Array(tags), len(tags)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, args, err := articleListQuery(tt.filter, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, cond := range tt.wantWhere {
+				if !strings.Contains(query, cond) {
+					t.Errorf("query has no %q: %s", cond, query)
+				}
+			}
+			// limit and offset follow the filter parameters
+			n := len(tt.wantArgs)
+			if limit := "LIMIT $" + strconv.Itoa(n+1) + " OFFSET $" + strconv.Itoa(n+2); !strings.Contains(query, limit) {
+				t.Errorf("query has no %q: %s", limit, query)
+			}
+			if want := append(append([]any{}, tt.wantArgs...), page.Limit, page.Offset); !reflect.DeepEqual(args, want) {
+				t.Errorf("args = %v, want %v", args, want)
+			}
+
+			// the count query numbers the same filter the same way
+			countQuery, countArgs := articleCountQuery(tt.filter)
+			for _, cond := range tt.wantWhere {
+				if !strings.Contains(countQuery, cond) {
+					t.Errorf("count query has no %q: %s", cond, countQuery)
+				}
+			}
+			if !reflect.DeepEqual(countArgs, tt.wantArgs) {
+				t.Errorf("count args = %v, want %v", countArgs, tt.wantArgs)
+			}
+		})
+	}
+}
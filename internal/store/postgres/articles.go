@@ -6,6 +6,7 @@ import (
 	"errors"
 
 	"github.com/critma/goblog/internal/store"
+	"github.com/lib/pq"
 )
 
 type ArticleStore struct {
@@ -18,38 +19,52 @@ func (s *ArticleStore) GetLastTen(ctx context.Context) ([]*store.LatestArticle,
 	`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, store.ErrNotFound
-		default:
-			return nil, err
-		}
+		return nil, err
 	}
-	var result []*store.LatestArticle
+	defer rows.Close()
+
+	result := make([]*store.LatestArticle, 0)
 	for rows.Next() {
 		art := &store.LatestArticle{}
-		rows.Scan(
+		if err := rows.Scan(
 			&art.ID,
 			&art.Title,
 			&art.AuthorName,
 			&art.Likes,
 			&art.PublishedAt,
-		)
+		); err != nil {
+			return nil, err
+		}
 		result = append(result, art)
 	}
-	return result, nil
+	return result, rows.Err()
+}
+
+const articleColumns = `
+	articles.id, articles.title, articles.content, articles.author_id, articles.likes,
+	articles.published_at, articles.updated_at, articles.version, articles.status, articles.comments_count
+`
+
+// scan destinations of articleColumns
+func articleDest(art *store.Article) []any {
+	return []any{
+		&art.ID,
+		&art.Title,
+		&art.Content,
+		&art.AuthorID,
+		&art.Likes,
+		&art.PublishedAt,
+		&art.UpdatedAt,
+		&art.Version,
+		&art.Status,
+		&art.CommentsCount,
+	}
 }
 
-// with author
 func (s *ArticleStore) GetByID(ctx context.Context, id int) (*store.Article, error) {
 	query := `
-	SELECT 
-		articles.*,
-		users.id,
-		users.username,
-		users.email
+	SELECT ` + articleColumns + `
 	FROM articles
-	JOIN users ON users.id = articles.author_id
 	WHERE articles.id = $1
 	`
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
@@ -61,19 +76,7 @@ func (s *ArticleStore) GetByID(ctx context.Context, id int) (*store.Article, err
 		ctx,
 		query,
 		id,
-	).Scan(
-		&art.ID,
-		&art.Title,
-		&art.Content,
-		&art.AuthorID,
-		&art.Likes,
-		&art.PublishedAt,
-		&art.UpdatedAt,
-
-		&art.User.ID,
-		&art.User.Username,
-		&art.User.Email,
-	); err != nil {
+	).Scan(articleDest(art)...); err != nil {
 		switch err {
 		case sql.ErrNoRows:
 			return nil, store.ErrNotFound
@@ -85,53 +88,95 @@ func (s *ArticleStore) GetByID(ctx context.Context, id int) (*store.Article, err
 }
 
 // with count of likes
-func (s *ArticleStore) GetByAuthor(ctx context.Context, UserId int, pq store.PaginatedQuery) ([]*store.Article, error) {
+func (s *ArticleStore) GetByAuthor(ctx context.Context, UserId int, pq store.PaginatedQuery, withDrafts bool) ([]*store.Article, error) {
 	query := `
-		SELECT *
+		SELECT ` + articleColumns + `
 		FROM articles
-		WHERE author_id = $1
+		WHERE author_id = $1 AND ($4 OR status = 'published')
+		ORDER BY published_at DESC, id DESC
 		LIMIT $2 OFFSET $3
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
 	defer cancel()
 
-	rows, err := s.db.QueryContext(ctx, query, UserId, pq.Limit, pq.Offset)
+	rows, err := s.db.QueryContext(ctx, query, UserId, pq.Limit, pq.Offset, withDrafts)
 	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, store.ErrNotFound
-		default:
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*store.Article, 0)
+	for rows.Next() {
+		art := &store.Article{}
+		if err := rows.Scan(articleDest(art)...); err != nil {
 			return nil, err
 		}
+		result = append(result, art)
+	}
+	return result, rows.Err()
+}
+
+func (s *ArticleStore) CountByAuthor(ctx context.Context, userID int, withDrafts bool) (int, error) {
+	query := `
+		SELECT count(*) FROM articles WHERE author_id = $1 AND ($2 OR status = 'published')
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID, withDrafts).Scan(&count)
+	return count, err
+}
+
+func (s *ArticleStore) Count(ctx context.Context, filter store.ArticleFilter) (int, error) {
+	query, args := articleCountQuery(filter)
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+func (s *ArticleStore) List(ctx context.Context, filter store.ArticleFilter, pq store.PaginatedQuery) ([]*store.Article, error) {
+	query, args, err := articleListQuery(filter, pq)
+	if err != nil {
+		return nil, err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
 	result := make([]*store.Article, 0)
 	for rows.Next() {
 		art := &store.Article{}
-		if err := rows.Scan(
-			&art.ID,
-			&art.Title,
-			&art.Content,
-			&art.AuthorID,
-			&art.Likes,
-			&art.PublishedAt,
-			&art.UpdatedAt,
-		); err != nil {
+		if err := rows.Scan(articleDest(art)...); err != nil {
 			return nil, err
 		}
 		result = append(result, art)
 	}
-	return result, nil
+	return result, rows.Err()
 }
 
 func (s *ArticleStore) Create(ctx context.Context, article *store.Article) (int, error) {
 	if article.AuthorID == 0 {
 		return 0, errors.New("author id is required")
 	}
+	if article.Status == "" {
+		article.Status = store.ArticlePublished
+	}
 	query := `
-		INSERT INTO articles (title, content, author_id)
-		VALUES ($1, $2, $3)
+		INSERT INTO articles (title, content, author_id, status)
+		VALUES ($1, $2, $3, $4)
 		RETURNING id
 	`
 
@@ -139,7 +184,13 @@ func (s *ArticleStore) Create(ctx context.Context, article *store.Article) (int,
 	defer cancel()
 
 	var id int
-	if err := s.db.QueryRowContext(ctx, query, article.Title, article.Content, article.AuthorID).Scan(&id); err != nil {
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, query, article.Title, article.Content, article.AuthorID, article.Status).Scan(&id); err != nil {
+			return translateError(err)
+		}
+		return setTags(ctx, tx, id, article.Tags)
+	})
+	if err != nil {
 		return 0, err
 	}
 
@@ -156,47 +207,117 @@ func (s *ArticleStore) Update(ctx context.Context, article *store.Article) (int,
 
 	query := `
 		UPDATE articles
-		SET title = $1, content = $2
-		WHERE articles.id = $3
-		RETURNING id
+		SET title = $1, content = $2, status = $5, version = version + 1, updated_at = now()
+		WHERE articles.id = $3 AND articles.version = $4
+		RETURNING id, version, updated_at
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
 	defer cancel()
 
 	var id int
-	if err := s.db.QueryRowContext(ctx, query, article.Title, article.Content, article.ID).Scan(&id); err != nil {
-		return 0, err
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, article.Title, article.Content, article.ID, article.Version, article.Status).
+			Scan(&id, &article.Version, &article.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if article.Tags == nil {
+			return nil
+		}
+		return setTags(ctx, tx, article.ID, article.Tags)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, s.missingVersion(ctx, article.ID)
+	}
+	if err != nil {
+		return 0, translateError(err)
 	}
 
 	return id, nil
 }
 
-func (s *ArticleStore) Delete(ctx context.Context, id int) error {
+// replaces tags of the article
+func setTags(ctx context.Context, tx *sql.Tx, articleID int, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM article_tags WHERE article_id = $1`, articleID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
 	query := `
-	DELETE FROM articles WHERE id = $1
+		INSERT INTO article_tags (article_id, tag)
+		SELECT $1, unnest($2::text[]) ON CONFLICT DO NOTHING
 	`
+	if _, err := tx.ExecContext(ctx, query, articleID, pq.Array(tags)); err != nil {
+		return translateError(err)
+	}
+	return nil
+}
 
-	res, err := s.db.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
+func (s *ArticleStore) GetTags(ctx context.Context, articleIDs []int) (map[int][]string, error) {
+	result := make(map[int][]string, len(articleIDs))
+	if len(articleIDs) == 0 {
+		return result, nil
 	}
 
-	rowsAffected, err := res.RowsAffected()
+	query := `
+		SELECT article_id, tag FROM article_tags WHERE article_id = ANY($1) ORDER BY article_id, tag
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, idArray(articleIDs))
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	if rowsAffected == 0 {
-		return store.ErrNotFound
+	for rows.Next() {
+		var (
+			id  int
+			tag string
+		)
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, err
+		}
+		result[id] = append(result[id], tag)
 	}
+	return result, rows.Err()
+}
 
-	return nil
+func (s *ArticleStore) Delete(ctx context.Context, id, version int) error {
+	query := `
+	DELETE FROM articles WHERE id = $1 AND version = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	err := execAffectingOne(ctx, s.db, query, id, version)
+	if errors.Is(err, store.ErrNotFound) {
+		return s.missingVersion(ctx, id)
+	}
+	return err
+}
+
+// tells why a versioned write matched no row
+func (s *ArticleStore) missingVersion(ctx context.Context, id int) error {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM articles WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return store.ErrVersionConflict
+	}
+	return store.ErrNotFound
 }
 
 func (s *ArticleStore) GetComments(ctx context.Context, articleID int, pq store.PaginatedQuery) ([]*store.Comment, error) {
 	query := `
-	SELECT * FROM comments WHERE article_id = $1 ORDER BY created_at DESC LIMIT $2 OFFSET $3
+	SELECT * FROM comments WHERE article_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
@@ -204,13 +325,9 @@ func (s *ArticleStore) GetComments(ctx context.Context, articleID int, pq store.
 
 	rows, err := s.db.QueryContext(ctx, query, articleID, pq.Limit, pq.Offset)
 	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, store.ErrNotFound
-		default:
-			return nil, err
-		}
+		return nil, err
 	}
+	defer rows.Close()
 	result := make([]*store.Comment, 0)
 	for rows.Next() {
 		comm := &store.Comment{}
@@ -225,7 +342,48 @@ func (s *ArticleStore) GetComments(ctx context.Context, articleID int, pq store.
 		}
 		result = append(result, comm)
 	}
-	return result, nil
+	return result, rows.Err()
+}
+
+// one query for a page of articles, row_number keeps the newest of each
+func (s *ArticleStore) GetLatestComments(ctx context.Context, articleIDs []int, perArticle int) (map[int][]*store.Comment, error) {
+	result := make(map[int][]*store.Comment, len(articleIDs))
+	if len(articleIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+	SELECT id, article_id, user_id, text, created_at FROM (
+		SELECT comments.*, row_number() OVER (PARTITION BY article_id ORDER BY created_at DESC, id DESC) AS n
+		FROM comments WHERE article_id = ANY($1)
+	) AS latest
+	WHERE n <= $2
+	ORDER BY article_id, n
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, idArray(articleIDs), perArticle)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		comm := &store.Comment{}
+		if err := rows.Scan(
+			&comm.ID,
+			&comm.ArticleID,
+			&comm.UserID,
+			&comm.Text,
+			&comm.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		result[comm.ArticleID] = append(result[comm.ArticleID], comm)
+	}
+	return result, rows.Err()
 }
 
 func (s *ArticleStore) AddComment(ctx context.Context, comment *store.Comment) (int, error) {
@@ -240,11 +398,10 @@ func (s *ArticleStore) AddComment(ctx context.Context, comment *store.Comment) (
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
 	defer cancel()
 
-	commentID := 0
 	if err := s.db.QueryRowContext(ctx, query, comment.ArticleID, comment.UserID, comment.Text).Scan(&comment.ID); err != nil {
-		return 0, err
+		return 0, translateError(err)
 	}
-	return commentID, nil
+	return comment.ID, nil
 }
 
 func (s *ArticleStore) AddLike(ctx context.Context, articleID, userID int) error {
@@ -256,14 +413,87 @@ func (s *ArticleStore) AddLike(ctx context.Context, articleID, userID int) error
 		INSERT INTO article_like (article_id, user_id) VALUES ($1, $2)
 	`
 
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	// a second like of the same user is a unique violation
 	if _, err := s.db.ExecContext(ctx, query, articleID, userID); err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return store.ErrNotFound
-		default:
-			return err
-		}
+		return translateError(err)
 	}
 
 	return nil
 }
+
+func (s *ArticleStore) AddBookmark(ctx context.Context, articleID, userID int) error {
+	query := `
+		INSERT INTO article_bookmarks (article_id, user_id) VALUES ($1, $2)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, query, articleID, userID); err != nil {
+		return translateError(err)
+	}
+	return nil
+}
+
+func (s *ArticleStore) DeleteBookmark(ctx context.Context, articleID, userID int) error {
+	query := `
+		DELETE FROM article_bookmarks WHERE article_id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, articleID, userID)
+}
+
+// one round trip for a page of articles, both lookups use the (user_id, article_id) indexes
+func (s *ArticleStore) GetViewerStates(ctx context.Context, userID int, articleIDs []int) (map[int]store.ArticleViewerState, error) {
+	result := make(map[int]store.ArticleViewerState, len(articleIDs))
+	if len(articleIDs) == 0 {
+		return result, nil
+	}
+
+	query := `
+		SELECT article_id, bool_or(liked), bool_or(bookmarked) FROM (
+			SELECT article_id, TRUE AS liked, FALSE AS bookmarked
+			FROM article_like WHERE user_id = $1 AND article_id = ANY($2)
+			UNION ALL
+			SELECT article_id, FALSE, TRUE
+			FROM article_bookmarks WHERE user_id = $1 AND article_id = ANY($2)
+		) AS states
+		GROUP BY article_id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID, idArray(articleIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id    int
+			state store.ArticleViewerState
+		)
+		if err := rows.Scan(&id, &state.Liked, &state.Bookmarked); err != nil {
+			return nil, err
+		}
+		result[id] = state
+	}
+	return result, rows.Err()
+}
+
+// ids as a postgres integer array parameter, for = ANY($n)
+func idArray(ids []int) any {
+	arr := make([]int64, len(ids))
+	for i, id := range ids {
+		arr[i] = int64(id)
+	}
+	return pq.Array(arr)
+}
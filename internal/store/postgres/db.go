@@ -8,7 +8,7 @@ import (
 	"github.com/critma/goblog/internal/store"
 )
 
-func NewConnection(addr string, maxOpenConns, maxIdleConns int, maxIdleTime string) (*sql.DB, error) {
+func NewConnection(addr string, maxOpenConns, maxIdleConns int, maxIdleTime time.Duration) (*sql.DB, error) {
 	db, err := sql.Open("postgres", addr)
 	if err != nil {
 		return nil, err
@@ -16,17 +16,13 @@ func NewConnection(addr string, maxOpenConns, maxIdleConns int, maxIdleTime stri
 
 	db.SetMaxIdleConns(maxIdleConns)
 	db.SetMaxOpenConns(maxOpenConns)
-
-	duration, err := time.ParseDuration(maxIdleTime)
-	if err != nil {
-		return nil, err
-	}
-	db.SetConnMaxIdleTime(duration)
+	db.SetConnMaxIdleTime(maxIdleTime)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err = db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -35,7 +31,49 @@ func NewConnection(addr string, maxOpenConns, maxIdleConns int, maxIdleTime stri
 
 func NewStorage(db *sql.DB) store.Storage {
 	return store.Storage{
-		Users:    &UserStore{db},
-		Articles: &ArticleStore{db},
+		Users:     &UserStore{db},
+		Articles:  &ArticleStore{db},
+		TwoFactor: &TwoFactorStore{db},
+		Passkeys:  &PasskeyStore{db},
+		Sessions:  &SessionStore{db},
+
+		LoginAttempts: &LoginAttemptStore{db},
+	}
+}
+
+func withTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// returns store.ErrNotFound when no row was changed
+func execAffectingOne(ctx context.Context, db execer, query string, args ...any) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
 	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return store.ErrNotFound
+	}
+
+	return nil
 }
@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	uniqueViolation     pq.ErrorCode = "23505"
+	foreignKeyViolation pq.ErrorCode = "23503"
+	checkViolation      pq.ErrorCode = "23514"
+	undefinedTable      pq.ErrorCode = "42P01"
+)
+
+// json field of constraints, the names are the ones postgres generates for db_init.sql and the migrations
+var constraintFields = map[string]string{
+	"users_username_key":                  "username",
+	"users_email_key":                     "email",
+	"users_role_check":                    "role",
+	"passkeys_credential_id_key":          "credential.id",
+	"passkeys_user_id_fkey":               "user_id",
+	"articles_author_id_fkey":             "author_id",
+	"comments_article_id_fkey":            "article_id",
+	"comments_user_id_fkey":               "user_id",
+	"article_like_article_id_user_id_key": "article_id",
+	"article_like_article_id_fkey":        "article_id",
+	"article_like_user_id_fkey":           "user_id",
+	"article_bookmarks_pkey":              "article_id",
+	"article_bookmarks_article_id_fkey":   "article_id",
+}
+
+// "Key (email)=(a@b.c) already exists."
+var detailKey = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// translateError turns constraint violations into *store.ConstraintError,
+// other errors are returned as they are
+func translateError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	var kind error
+	switch pqErr.Code {
+	case uniqueViolation:
+		kind = store.ErrExists
+	case foreignKeyViolation:
+		kind = store.ErrInvalidReference
+	case checkViolation:
+		kind = store.ErrInvalidValue
+	default:
+		return err
+	}
+
+	field, ok := constraintFields[pqErr.Constraint]
+	if !ok {
+		// first column of the key, constraints added later still name something
+		if m := detailKey.FindStringSubmatch(pqErr.Detail); m != nil {
+			field, _, _ = strings.Cut(m[1], ",")
+		}
+	}
+
+	return &store.ConstraintError{
+		Kind:       kind,
+		Constraint: pqErr.Constraint,
+		Field:      field,
+		Err:        err,
+	}
+}
+
+func isUndefinedTable(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == undefinedTable
+}
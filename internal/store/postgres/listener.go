@@ -0,0 +1,118 @@
+package postgres
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// channel notified by the cache invalidation triggers
+const InvalidationChannel = "cache_invalidation"
+
+const (
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = time.Minute
+	// idle connection is pinged to notice a silently dropped one
+	listenerPingInterval = time.Minute
+)
+
+type Invalidator interface {
+	InvalidateUser(id int)
+	InvalidateArticle(id int)
+	Purge()
+}
+
+// InvalidationListener evicts entries changed by any api instance.
+// Notifications sent while the connection was lost are unknown, so the whole cache is purged on reconnect.
+type InvalidationListener struct {
+	listener *pq.Listener
+	target   Invalidator
+	logger   *zap.SugaredLogger
+}
+
+func NewInvalidationListener(addr string, target Invalidator, logger *zap.SugaredLogger) *InvalidationListener {
+	l := &InvalidationListener{target: target, logger: logger}
+	l.listener = pq.NewListener(addr, listenerMinReconnect, listenerMaxReconnect, l.onEvent)
+	return l
+}
+
+// Run listens until ctx is done
+func (l *InvalidationListener) Run(ctx context.Context) error {
+	// closing also interrupts Listen waiting for the first connection
+	stop := context.AfterFunc(ctx, func() { l.listener.Close() })
+	defer stop()
+	defer l.listener.Close()
+
+	if err := l.listener.Listen(InvalidationChannel); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	ticker := time.NewTicker(listenerPingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n, ok := <-l.listener.Notify:
+			if !ok {
+				return nil
+			}
+			// nil is sent after reconnect
+			if n == nil {
+				l.target.Purge()
+				continue
+			}
+			l.invalidate(n.Extra)
+		case <-ticker.C:
+			if err := l.listener.Ping(); err != nil {
+				l.logger.Warnw("cache listener ping", "error", err.Error())
+			}
+		}
+	}
+}
+
+func (l *InvalidationListener) invalidate(payload string) {
+	entity, rawID, _ := strings.Cut(payload, ":")
+	id, err := strconv.Atoi(rawID)
+	if err != nil {
+		l.logger.Warnw("cache listener payload", "payload", payload)
+		l.target.Purge()
+		return
+	}
+
+	switch entity {
+	case "user":
+		l.target.InvalidateUser(id)
+	case "article":
+		l.target.InvalidateArticle(id)
+	default:
+		l.logger.Warnw("cache listener payload", "payload", payload)
+		l.target.Purge()
+	}
+}
+
+func (l *InvalidationListener) onEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		l.logger.Warnw("cache listener disconnected", "error", errString(err))
+	case pq.ListenerEventConnectionAttemptFailed:
+		l.logger.Warnw("cache listener reconnect failed", "error", errString(err))
+	case pq.ListenerEventReconnected:
+		l.logger.Infow("cache listener reconnected")
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func (s *LoginAttemptStore) Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(*store.LoginAttempt) bool) (*store.LoginAttempt, error) {
+	// a row to lock, without failures it allows any attempt
+	insert := `
+	INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 0, now())
+	ON CONFLICT (key) DO NOTHING
+	`
+	selectForUpdate := `
+	SELECT failures, last_failure, locked_until FROM login_attempts WHERE key = $1 FOR UPDATE
+	`
+	count := `
+	UPDATE login_attempts SET
+		failures = CASE
+			WHEN last_failure < now() - make_interval(secs => $2) THEN 1
+			ELSE failures + 1
+		END,
+		last_failure = now()
+	WHERE key = $1
+	RETURNING failures, last_failure, locked_until
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	var reserved *store.LoginAttempt
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, insert, key); err != nil {
+			return err
+		}
+
+		previous, err := scanLoginAttempt(tx.QueryRowContext(ctx, selectForUpdate, key), key)
+		if err != nil {
+			return err
+		}
+		if !allow(previous) {
+			return nil
+		}
+
+		reserved, err = scanLoginAttempt(tx.QueryRowContext(ctx, count, key, resetAfter.Seconds()), key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reserved, nil
+}
+
+func (s *LoginAttemptStore) Release(ctx context.Context, key string) error {
+	query := `
+	UPDATE login_attempts SET failures = failures - 1 WHERE key = $1 AND failures > 0
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
+
+func scanLoginAttempt(row scanner, key string) (*store.LoginAttempt, error) {
+	attempt := &store.LoginAttempt{Key: key}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&attempt.Failures, &attempt.LastFailure, &lockedUntil); err != nil {
+		return nil, err
+	}
+	attempt.LockedUntil = lockedUntil.Time
+
+	return attempt, nil
+}
+
+func (s *LoginAttemptStore) Lock(ctx context.Context, key string, until time.Time) error {
+	query := `
+	UPDATE login_attempts SET locked_until = $1 WHERE key = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, until, key)
+}
+
+func (s *LoginAttemptStore) Reset(ctx context.Context, key string) error {
+	query := `
+	DELETE FROM login_attempts WHERE key = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, key)
+	return err
+}
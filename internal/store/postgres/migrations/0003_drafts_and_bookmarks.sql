@@ -0,0 +1,19 @@
+-- drafts are only visible to the author
+ALTER TABLE articles
+    ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'published'));
+
+CREATE TABLE IF NOT EXISTS article_bookmarks (
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (article_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_like_user ON article_like(user_id, article_id);
+CREATE INDEX IF NOT EXISTS idx_article_bookmarks_user ON article_bookmarks(user_id, article_id);
+
+CREATE OR REPLACE VIEW latest_articles AS
+    SELECT a.id, a.title, u.username as author_name, a.likes, a.published_at
+    FROM articles a JOIN users u ON a.author_id = u.id
+    WHERE a.status = 'published'
+    ORDER BY a.published_at DESC LIMIT 10;
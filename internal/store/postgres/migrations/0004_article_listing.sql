@@ -0,0 +1,41 @@
+-- kept by a trigger for sorting
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS comments_count INTEGER NOT NULL DEFAULT 0;
+
+-- existing comments, the trigger below counts the new ones. Counting is not an edit,
+-- updated_at and version are kept
+ALTER TABLE articles DISABLE TRIGGER updated_at_articles, DISABLE TRIGGER version_articles;
+UPDATE articles SET comments_count = c.count
+FROM (SELECT article_id, count(*) AS count FROM comments GROUP BY article_id) c
+WHERE articles.id = c.article_id AND articles.comments_count <> c.count;
+ALTER TABLE articles ENABLE TRIGGER updated_at_articles, ENABLE TRIGGER version_articles;
+
+CREATE TABLE IF NOT EXISTS article_tags (
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    tag VARCHAR(50) NOT NULL,
+    PRIMARY KEY (article_id, tag)
+);
+
+-- orders of article listings, read backwards for ascending sorts
+CREATE INDEX IF NOT EXISTS idx_articles_published_at ON articles(status, published_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_articles_likes ON articles(status, likes DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_articles_comments_count ON articles(status, comments_count DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_articles_updated_at ON articles(status, updated_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_articles_author_published_at ON articles(author_id, status, published_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_article_tags_tag ON article_tags(tag, article_id);
+
+CREATE OR REPLACE FUNCTION update_article_comments_count()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE articles SET comments_count = comments_count + 1 WHERE id = NEW.article_id;
+    ELSIF TG_OP = 'DELETE' THEN
+        UPDATE articles SET comments_count = comments_count - 1 WHERE id = OLD.article_id AND comments_count > 0;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS update_article_comments ON comments;
+CREATE TRIGGER update_article_comments
+    AFTER INSERT OR DELETE ON comments
+    FOR EACH ROW EXECUTE PROCEDURE update_article_comments_count();
@@ -0,0 +1,6 @@
+-- likes and comments are counted in the row, only edits get a new version and updated_at,
+-- which ArticleStore.Update sets
+DROP TRIGGER IF EXISTS version_articles ON articles;
+DROP TRIGGER IF EXISTS updated_at_articles ON articles;
+DROP FUNCTION IF EXISTS increment_version();
+DROP FUNCTION IF EXISTS update_modified_column();
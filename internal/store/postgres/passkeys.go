@@ -0,0 +1,176 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+type PasskeyStore struct {
+	db *sql.DB
+}
+
+func (s *PasskeyStore) CreateChallenge(ctx context.Context, challenge *store.WebAuthnChallenge) error {
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	// abandoned ceremonies are cleaned up on the way
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM webauthn_challenges WHERE expires_at < now()`); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO webauthn_challenges (challenge, kind, user_id, expires_at)
+	VALUES ($1, $2, NULLIF($3, 0), $4)
+	`
+	_, err := s.db.ExecContext(ctx, query, challenge.Challenge, challenge.Kind, challenge.UserID, challenge.ExpiresAt)
+	return err
+}
+
+func (s *PasskeyStore) ConsumeChallenge(ctx context.Context, challenge []byte, kind string) (*store.WebAuthnChallenge, error) {
+	query := `
+	DELETE FROM webauthn_challenges
+	WHERE challenge = $1 AND kind = $2 AND expires_at > now()
+	RETURNING challenge, kind, COALESCE(user_id, 0), expires_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	ch := &store.WebAuthnChallenge{}
+	if err := s.db.QueryRowContext(ctx, query, challenge, kind).Scan(
+		&ch.Challenge,
+		&ch.Kind,
+		&ch.UserID,
+		&ch.ExpiresAt,
+	); err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, store.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return ch, nil
+}
+
+func (s *PasskeyStore) Create(ctx context.Context, passkey *store.Passkey) error {
+	query := `
+	INSERT INTO passkeys (user_id, credential_id, public_key, algorithm, sign_count, name)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		passkey.UserID,
+		passkey.CredentialID,
+		passkey.PublicKey,
+		passkey.Algorithm,
+		int64(passkey.SignCount),
+		passkey.Name,
+	).Scan(
+		&passkey.ID,
+		&passkey.CreatedAt,
+	)
+	return translateError(err)
+}
+
+const passkeyColumns = `
+	id, user_id, credential_id, public_key, algorithm, sign_count, name, created_at, last_used_at
+`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPasskey(row scanner) (*store.Passkey, error) {
+	p := &store.Passkey{}
+	var signCount int64
+	if err := row.Scan(
+		&p.ID,
+		&p.UserID,
+		&p.CredentialID,
+		&p.PublicKey,
+		&p.Algorithm,
+		&signCount,
+		&p.Name,
+		&p.CreatedAt,
+		&p.LastUsedAt,
+	); err != nil {
+		return nil, err
+	}
+	p.SignCount = uint32(signCount)
+	return p, nil
+}
+
+func (s *PasskeyStore) GetByCredentialID(ctx context.Context, credentialID []byte) (*store.Passkey, error) {
+	query := `
+	SELECT ` + passkeyColumns + ` FROM passkeys WHERE credential_id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	p, err := scanPasskey(s.db.QueryRowContext(ctx, query, credentialID))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, store.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (s *PasskeyStore) GetByUser(ctx context.Context, userID int) ([]*store.Passkey, error) {
+	query := `
+	SELECT ` + passkeyColumns + ` FROM passkeys WHERE user_id = $1 ORDER BY created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*store.Passkey, 0)
+	for rows.Next() {
+		p, err := scanPasskey(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, p)
+	}
+	return result, rows.Err()
+}
+
+func (s *PasskeyStore) UpdateSignCount(ctx context.Context, id int, signCount uint32) error {
+	query := `
+	UPDATE passkeys SET sign_count = $1, last_used_at = now() WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, int64(signCount), id)
+}
+
+func (s *PasskeyStore) Delete(ctx context.Context, id, userID int) error {
+	query := `
+	DELETE FROM passkeys WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, id, userID)
+}
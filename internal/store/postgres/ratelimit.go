@@ -0,0 +1,81 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/critma/goblog/internal/ratelimit"
+	"github.com/critma/goblog/internal/store"
+)
+
+const (
+	rateLimitSweepInterval = time.Hour
+	// buckets idle for longer than limit period are full, limits are not expected to exceed a day
+	rateLimitIdleTTL = 24 * time.Hour
+)
+
+// RateLimitStore shares rate limit buckets between api instances
+type RateLimitStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewRateLimitStore(db *sql.DB) *RateLimitStore {
+	return &RateLimitStore{db: db, lastSweep: time.Now()}
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	if err := s.sweep(ctx); err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	// the bucket is refilled and taken in one statement by the database clock shared by all instances.
+	// A denied take stores its tokens less one, so negative tokens tell it apart in RETURNING,
+	// the next refill adds the one back.
+	query := `
+	INSERT INTO rate_limit_buckets AS b (key, tokens, updated_at)
+	VALUES ($1, $2::double precision - 1, now())
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = LEAST(
+			$2::double precision,
+			CASE WHEN b.tokens < 0 THEN b.tokens + 1 ELSE b.tokens END
+				+ GREATEST(0, EXTRACT(EPOCH FROM now() - b.updated_at)::double precision) * $3::double precision
+		) - 1,
+		updated_at = GREATEST(b.updated_at, now())
+	RETURNING tokens
+	`
+
+	var tokens float64
+	if err := s.db.QueryRowContext(ctx, query, key, limit.Requests, limit.Rate()).Scan(&tokens); err != nil {
+		return ratelimit.Result{}, err
+	}
+
+	allowed := tokens >= 0
+	if !allowed {
+		tokens++
+	}
+	return ratelimit.NewResult(limit, tokens, allowed), nil
+}
+
+// removes buckets which are certainly refilled, at most once per interval
+func (s *RateLimitStore) sweep(ctx context.Context) error {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < rateLimitSweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `
+	DELETE FROM rate_limit_buckets WHERE updated_at < now() - make_interval(secs => $1)
+	`, rateLimitIdleTTL.Seconds())
+	return err
+}
@@ -0,0 +1,122 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+// SchemaVersion is the schema this code works with, the version of the latest file in migrations
+const SchemaVersion = 5
+
+// instances starting together apply migrations one at a time
+const migrationLockKey = 7_236_105
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change, files are named like 0001_name.sql
+type Migration struct {
+	Version int
+	Name    string
+	sql     string
+}
+
+// Migrations returns the embedded migrations ordered by version
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: name must start with its version", e.Name())
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, Migration{Version: version, Name: e.Name(), sql: string(data)})
+	}
+	// ReadDir sorts by name, the zero padded versions keep it numeric
+	for i, m := range res {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s: expected version %d", m.Name, i+1)
+		}
+	}
+	return res, nil
+}
+
+// Migrate applies the pending migrations, each one in a transaction recording its version.
+// A database created by scripts/postgres/db_init.sql is at version 0
+func Migrate(ctx context.Context, db *sql.DB) (applied []Migration, err error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		err := withTx(ctx, db, func(tx *sql.Tx) error {
+			// released on commit, another instance may have applied the migration meanwhile
+			if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockKey); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INTEGER PRIMARY KEY,
+				applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`); err != nil {
+				return err
+			}
+
+			var done bool
+			err := tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)
+			`, m.Version).Scan(&done)
+			if err != nil || done {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.Version); err != nil {
+				return err
+			}
+			applied = append(applied, m)
+			return nil
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %s: %w", m.Name, err)
+		}
+	}
+	return applied, nil
+}
+
+// CurrentSchemaVersion returns the latest applied schema version, 0 when none
+func CurrentSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	query := `
+	SELECT COALESCE(MAX(version), 0) FROM schema_migrations
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	var version int
+	err := db.QueryRowContext(ctx, query).Scan(&version)
+	if isUndefinedTable(err) {
+		// the baseline schema has no migrations table
+		return 0, nil
+	}
+	return version, err
+}
@@ -0,0 +1,21 @@
+package postgres
+
+import "testing"
+
+func TestMigrations(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	if last := migrations[len(migrations)-1].Version; last != SchemaVersion {
+		t.Errorf("latest migration is %d, SchemaVersion is %d", last, SchemaVersion)
+	}
+	for _, m := range migrations {
+		if m.sql == "" {
+			t.Errorf("%s is empty", m.Name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+const sessionColumns = `
+	id, user_id, token_hash, csrf_token, user_agent, ip, created_at, last_seen_at, expires_at
+`
+
+func scanSession(row scanner) (*store.Session, error) {
+	s := &store.Session{}
+	if err := row.Scan(
+		&s.ID,
+		&s.UserID,
+		&s.TokenHash,
+		&s.CSRFToken,
+		&s.UserAgent,
+		&s.IP,
+		&s.CreatedAt,
+		&s.LastSeenAt,
+		&s.ExpiresAt,
+	); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SessionStore) Create(ctx context.Context, session *store.Session) error {
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	// sessions which were never logged out are cleaned up on the next login
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND expires_at < now()`, session.UserID); err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO sessions (user_id, token_hash, csrf_token, user_agent, ip, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, last_seen_at
+	`
+	err := s.db.QueryRowContext(
+		ctx,
+		query,
+		session.UserID,
+		session.TokenHash,
+		session.CSRFToken,
+		session.UserAgent,
+		session.IP,
+		session.ExpiresAt,
+	).Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+	)
+	return translateError(err)
+}
+
+func (s *SessionStore) GetByTokenHash(ctx context.Context, tokenHash string) (*store.Session, error) {
+	query := `
+	SELECT ` + sessionColumns + ` FROM sessions WHERE token_hash = $1 AND expires_at > now()
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	session, err := scanSession(s.db.QueryRowContext(ctx, query, tokenHash))
+	if err != nil {
+		switch err {
+		case sql.ErrNoRows:
+			return nil, store.ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+func (s *SessionStore) GetByUser(ctx context.Context, userID int) ([]*store.Session, error) {
+	query := `
+	SELECT ` + sessionColumns + ` FROM sessions WHERE user_id = $1 AND expires_at > now()
+	ORDER BY last_seen_at DESC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*store.Session, 0)
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, session)
+	}
+	return result, rows.Err()
+}
+
+func (s *SessionStore) Touch(ctx context.Context, id int) error {
+	query := `
+	UPDATE sessions SET last_seen_at = now() WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, id)
+}
+
+func (s *SessionStore) Delete(ctx context.Context, id, userID int) error {
+	query := `
+	DELETE FROM sessions WHERE id = $1 AND user_id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, id, userID)
+}
+
+func (s *SessionStore) DeleteOthers(ctx context.Context, userID, keepID int) error {
+	query := `
+	DELETE FROM sessions WHERE user_id = $1 AND id <> $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, query, userID, keepID)
+	return err
+}
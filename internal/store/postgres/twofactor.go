@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/critma/goblog/internal/store"
+)
+
+type TwoFactorStore struct {
+	db *sql.DB
+}
+
+func (s *TwoFactorStore) SetSecret(ctx context.Context, userID int, secret string) error {
+	query := `
+	UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, secret, userID)
+}
+
+func (s *TwoFactorStore) ReplaceSecret(ctx context.Context, userID int, old, secret string) error {
+	query := `
+	UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_secret = $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, secret, userID, old)
+}
+
+func (s *TwoFactorStore) Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error {
+	query := `
+	UPDATE users SET totp_enabled = TRUE, totp_last_step = $1
+	WHERE id = $2 AND totp_secret IS NOT NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := execAffectingOne(ctx, tx, query, step, userID); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+func (s *TwoFactorStore) Disable(ctx context.Context, userID int) error {
+	query := `
+	UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := execAffectingOne(ctx, tx, query, userID); err != nil {
+			return err
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, nil)
+	})
+}
+
+func (s *TwoFactorStore) SetRequired(ctx context.Context, userID int, required bool) error {
+	query := `
+	UPDATE users SET totp_required = $1 WHERE id = $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, required, userID)
+}
+
+func (s *TwoFactorStore) UseStep(ctx context.Context, userID int, step int64) error {
+	query := `
+	UPDATE users SET totp_last_step = $1 WHERE id = $2 AND totp_last_step < $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, step, userID)
+}
+
+func (s *TwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) error {
+	query := `
+	UPDATE user_recovery_codes SET used_at = now()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return execAffectingOne(ctx, s.db, query, userID, codeHash)
+}
+
+func (s *TwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.ExecContext(ctx, `
+		INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+		`, userID, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -11,23 +11,25 @@ type UserStore struct {
 	db *sql.DB
 }
 
-func (s *UserStore) GetByID(ctx context.Context, id int) (*store.User, error) {
-	query := `
-	SELECT * FROM users WHERE id = $1
-	`
+const userColumns = `
+	id, username, password_hash, email, created_at, role,
+	COALESCE(totp_secret, ''), totp_enabled, totp_required, totp_last_step
+`
+
+func scanUser(row scanner) (*store.User, error) {
 	user := &store.User{}
-	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
-	defer cancel()
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		id,
-	).Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password.Hash,
 		&user.Email,
-		&user.CreatedAt)
+		&user.CreatedAt,
+		&user.Role,
+		&user.TwoFactor.Secret,
+		&user.TwoFactor.Enabled,
+		&user.TwoFactor.Required,
+		&user.TwoFactor.LastStep,
+	)
 	if err != nil {
 		switch err {
 		case sql.ErrNoRows:
@@ -39,40 +41,58 @@ func (s *UserStore) GetByID(ctx context.Context, id int) (*store.User, error) {
 	return user, nil
 }
 
-func (s *UserStore) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+func (s *UserStore) GetByID(ctx context.Context, id int) (*store.User, error) {
 	query := `
-	SELECT * FROM users WHERE email = $1
+	SELECT ` + userColumns + ` FROM users WHERE id = $1
 	`
-	user := &store.User{}
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
 
+	return scanUser(s.db.QueryRowContext(ctx, query, id))
+}
+
+func (s *UserStore) GetByIDs(ctx context.Context, ids []int) (map[int]*store.User, error) {
+	result := make(map[int]*store.User, len(ids))
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query := `
+	SELECT ` + userColumns + ` FROM users WHERE id = ANY($1)
+	`
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
 	defer cancel()
 
-	err := s.db.QueryRowContext(
-		ctx,
-		query,
-		email,
-	).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Password.Hash,
-		&user.Email,
-		&user.CreatedAt)
+	rows, err := s.db.QueryContext(ctx, query, idArray(ids))
 	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			return nil, store.ErrNotFound
-		default:
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
 			return nil, err
 		}
+		result[user.ID] = user
 	}
-	return user, nil
+	return result, rows.Err()
+}
+
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (*store.User, error) {
+	query := `
+	SELECT ` + userColumns + ` FROM users WHERE email = $1
+	`
+	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
+	defer cancel()
+
+	return scanUser(s.db.QueryRowContext(ctx, query, email))
 }
 
 func (s *UserStore) Create(ctx context.Context, user *store.User) error {
 	query := `
 	INSERT INTO users (username, password_hash, email)
-	VALUES ($1, $2, $3) RETURNING id, created_at
+	VALUES ($1, $2, $3) RETURNING id, created_at, role
 	`
 
 	ctx, cancel := context.WithTimeout(ctx, store.QueryTimeoutDuration)
@@ -86,9 +106,10 @@ func (s *UserStore) Create(ctx context.Context, user *store.User) error {
 	).Scan(
 		&user.ID,
 		&user.CreatedAt,
+		&user.Role,
 	)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
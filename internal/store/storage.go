@@ -3,31 +3,127 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
 var (
 	ErrNotFound          = errors.New("res not found")
 	ErrExists            = errors.New("res already exists")
+	ErrVersionConflict   = errors.New("res version conflict")
+	ErrInvalidReference  = errors.New("res references a missing res")
+	ErrInvalidValue      = errors.New("res value is not allowed")
 	QueryTimeoutDuration = time.Second * 10
 )
 
+// ConstraintError is a write rejected by a database constraint,
+// errors.Is matches it with ErrExists, ErrInvalidReference or ErrInvalidValue
+type ConstraintError struct {
+	Kind       error
+	Constraint string
+	// json name of the offending field, empty when the constraint is not known
+	Field string
+	Err   error
+}
+
+func (e *ConstraintError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%v: %s", e.Kind, e.Constraint)
+	}
+	return fmt.Sprintf("%v: %s (%s)", e.Kind, e.Field, e.Constraint)
+}
+
+func (e *ConstraintError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
 type Storage struct {
 	Users interface {
 		GetByID(context.Context, int) (*User, error)
+		// users of ids in one query, unknown ids are absent
+		GetByIDs(ctx context.Context, ids []int) (map[int]*User, error)
 		GetByEmail(ctx context.Context, email string) (*User, error)
 		Create(context.Context, *User) error
 	}
 	Articles interface {
 		GetLastTen(context.Context) ([]*LatestArticle, error)
 		GetByID(context.Context, int) (*Article, error)
-		GetByAuthor(ctx context.Context, UserId int, pq PaginatedQuery) ([]*Article, error)
+		// drafts are included for the author only
+		GetByAuthor(ctx context.Context, UserId int, pq PaginatedQuery, withDrafts bool) ([]*Article, error)
+		CountByAuthor(ctx context.Context, userID int, withDrafts bool) (int, error)
+		// sort fields must be of ArticleSortFields
+		List(ctx context.Context, filter ArticleFilter, pq PaginatedQuery) ([]*Article, error)
+		// number of articles List would return without pagination
+		Count(ctx context.Context, filter ArticleFilter) (int, error)
+		// tags of each of articleIDs in one query, ids without tags are absent
+		GetTags(ctx context.Context, articleIDs []int) (map[int][]string, error)
 		Create(ctx context.Context, article *Article) (int, error)
+		// updates article of article.Version and sets the new one,
+		// ErrVersionConflict when it was changed in between
 		Update(ctx context.Context, article *Article) (int, error)
-		Delete(ctx context.Context, id int) error
+		// ErrVersionConflict when version is not current
+		Delete(ctx context.Context, id, version int) error
 		GetComments(ctx context.Context, articleID int, pq PaginatedQuery) ([]*Comment, error)
+		// up to perArticle newest comments of each of articleIDs in one query
+		GetLatestComments(ctx context.Context, articleIDs []int, perArticle int) (map[int][]*Comment, error)
 		AddComment(ctx context.Context, comment *Comment) (int, error)
 		// DeleteComment(ctx context.Context, id int) error
 		AddLike(ctx context.Context, articleID, userID int) error
+		AddBookmark(ctx context.Context, articleID, userID int) error
+		// ErrNotFound when the article is not bookmarked
+		DeleteBookmark(ctx context.Context, articleID, userID int) error
+		// state of the user for each of articleIDs in one query, ids without any are absent
+		GetViewerStates(ctx context.Context, userID int, articleIDs []int) (map[int]ArticleViewerState, error)
+	}
+	TwoFactor interface {
+		SetSecret(ctx context.Context, userID int, secret string) error
+		// ReplaceSecret keeps the two-factor state, ErrNotFound when the secret is not old anymore
+		ReplaceSecret(ctx context.Context, userID int, old, secret string) error
+		// enables two-factor and replaces recovery codes
+		Enable(ctx context.Context, userID int, step int64, recoveryCodeHashes []string) error
+		Disable(ctx context.Context, userID int) error
+		SetRequired(ctx context.Context, userID int, required bool) error
+		// ErrNotFound when step was already used
+		UseStep(ctx context.Context, userID int, step int64) error
+		// ErrNotFound when code is unknown or already used
+		UseRecoveryCode(ctx context.Context, userID int, codeHash string) error
+		ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	}
+	Passkeys interface {
+		CreateChallenge(ctx context.Context, challenge *WebAuthnChallenge) error
+		// deletes the challenge, ErrNotFound when it is unknown or expired
+		ConsumeChallenge(ctx context.Context, challenge []byte, kind string) (*WebAuthnChallenge, error)
+		Create(ctx context.Context, passkey *Passkey) error
+		GetByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error)
+		GetByUser(ctx context.Context, userID int) ([]*Passkey, error)
+		UpdateSignCount(ctx context.Context, id int, signCount uint32) error
+		Delete(ctx context.Context, id, userID int) error
+	}
+	Sessions interface {
+		// deletes expired sessions of the user on the way
+		Create(ctx context.Context, session *Session) error
+		// ErrNotFound when the session is unknown or expired
+		GetByTokenHash(ctx context.Context, tokenHash string) (*Session, error)
+		GetByUser(ctx context.Context, userID int) ([]*Session, error)
+		// sets last seen to now
+		Touch(ctx context.Context, id int) error
+		Delete(ctx context.Context, id, userID int) error
+		// deletes sessions of the user except keepID
+		DeleteOthers(ctx context.Context, userID, keepID int) error
+	}
+	LoginAttempts interface {
+		// Reserve counts an attempt of key as failed before it is checked. allow gets the previous
+		// attempts under a row lock, so concurrent attempts of a key are checked one after another.
+		// Nothing is counted and nil is returned when allow refuses.
+		// Failures older than resetAfter are forgotten before counting
+		Reserve(ctx context.Context, key string, resetAfter time.Duration, allow func(*LoginAttempt) bool) (*LoginAttempt, error)
+		// Release takes back a reserved attempt which did not fail
+		Release(ctx context.Context, key string) error
+		Lock(ctx context.Context, key string, until time.Time) error
+		Reset(ctx context.Context, key string) error
 	}
 }
@@ -0,0 +1,211 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const scopeName = "github.com/critma/goblog"
+
+// OTLP/JSON trace request, ids are hex strings and 64 bit integers are decimal strings
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+// values of OTLP StatusCode
+const (
+	statusUnset = 0
+	statusError = 2
+)
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func toOTLPValue(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpValue{StringValue: &v}
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+func encodeOTLP(service string, spans []*Span) ([]byte, error) {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: statusUnset},
+		}
+		if s.ParentID.IsValid() {
+			span.ParentSpanID = s.ParentID.String()
+		}
+		for _, attr := range s.attributes {
+			span.Attributes = append(span.Attributes, otlpKeyValue{attr.Key, toOTLPValue(attr.Value)})
+		}
+		if s.err != nil {
+			span.Status = otlpStatus{Code: statusError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+
+		out = append(out, span)
+	}
+
+	return json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{"service.name", toOTLPValue(service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: scopeName},
+			Spans: out,
+		}},
+	}}})
+}
+
+// OTLPExporter sends spans to an OTLP/HTTP collector with JSON encoding
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter takes the collector base url, e.g. http://localhost:4318
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		url:    strings.TrimRight(endpoint, "/") + "/v1/traces",
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp export: %s", resp.Status)
+	}
+	return nil
+}
+
+// FileExporter appends every batch as a line of OTLP/JSON, for local use
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: file}, nil
+}
+
+func (e *FileExporter) Export(_ context.Context, service string, spans []*Span) error {
+	body, err := encodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.file.Write(append(body, '\n'))
+	return err
+}
+
+// Close syncs the spans written so far to disk, the tracer calls it when it stops
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.file.Sync(); err != nil {
+		e.file.Close()
+		return err
+	}
+	return e.file.Close()
+}
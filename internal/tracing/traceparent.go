@@ -0,0 +1,57 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+
+	flagSampled = 0x01
+)
+
+// ParseTraceparent parses W3C Trace Context header "version-traceid-spanid-flags"
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+	// future versions may append fields, version 00 has exactly four
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) {
+		return SpanContext{}, false
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&flagSampled != 0
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// Traceparent formats sc as W3C Trace Context header
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// lowercase hex of exactly len(dst) bytes
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
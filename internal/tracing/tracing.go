@@ -0,0 +1,248 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) IsValid() bool  { return id != SpanID{} }
+
+// SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanKind int
+
+// values of OTLP SpanKind
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+type Span struct {
+	tracer *Tracer
+
+	SpanContext
+	ParentID SpanID
+	Kind     SpanKind
+	Start    time.Time
+
+	mu         sync.Mutex
+	name       string
+	end        time.Time
+	attributes []Attribute
+	err        error
+	ended      bool
+}
+
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.name = name
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, Attribute{key, value})
+}
+
+// RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// End finishes the span and queues it for export, only the first call counts
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if s.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext returns the current span or the remote parent
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
+
+// ContextWithRemoteParent makes the next started span a child of a span of another service
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	batchInterval = time.Second * 5
+	flushTimeout  = time.Second * 10
+)
+
+type Exporter interface {
+	Export(ctx context.Context, service string, spans []*Span) error
+}
+
+// Tracer creates spans and exports them in batches.
+// Without exporter spans still get ids, for log correlation, but are not recorded.
+type Tracer struct {
+	service  string
+	exporter Exporter
+	queue    chan *Span
+	dropped  atomic.Uint64
+	onError  func(error)
+}
+
+// NewTracer creates a tracer, exporter may be nil, onError receives export failures
+func NewTracer(service string, exporter Exporter, onError func(error)) *Tracer {
+	return &Tracer{
+		service:  service,
+		exporter: exporter,
+		queue:    make(chan *Span, queueSize),
+		onError:  onError,
+	}
+}
+
+// Start begins a span, child of the span or remote parent in ctx
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+
+	span := &Span{
+		tracer: t,
+		name:   name,
+		Kind:   KindInternal,
+		Start:  time.Now(),
+	}
+	if parent.IsValid() {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+		span.Sampled = parent.Sampled && t.exporter != nil
+	} else {
+		rand.Read(span.TraceID[:])
+		span.Sampled = t.exporter != nil
+	}
+	rand.Read(span.SpanID[:])
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case t.queue <- span:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// exporters holding a file or connection are closed after the last flush
+func (t *Tracer) closeExporter() {
+	closer, ok := t.exporter.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+// Dropped returns the number of spans lost because the export queue was full
+func (t *Tracer) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Run exports queued spans until ctx is done, then flushes the rest
+func (t *Tracer) Run(ctx context.Context) error {
+	if t.exporter == nil {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(ctx, t.service, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+			defer cancel()
+			for {
+				select {
+				case span := <-t.queue:
+					batch = append(batch, span)
+				default:
+					flush(flushCtx)
+					t.closeExporter()
+					return nil
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("invalid CBOR data")
+
+// maximum nesting, authenticator data never comes close to it
+const cborMaxDepth = 16
+
+// decodeCBOR decodes the first CBOR item of data (RFC 8949) and returns
+// it together with the number of consumed bytes.
+// Only the subset used by WebAuthn is supported: integers, byte and text strings,
+// arrays, maps, tags (skipped) and simple values.
+func decodeCBOR(data []byte) (any, int, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("%w: nesting too deep", ErrInvalidCBOR)
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		return d.bytes(arg)
+	case 3:
+		b, err := d.bytes(arg)
+		return string(b), err
+	case 4:
+		if arg > uint64(len(d.data)) {
+			return nil, fmt.Errorf("%w: array too long", ErrInvalidCBOR)
+		}
+		arr := make([]any, 0, arg)
+		for range arg {
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	case 5:
+		if arg > uint64(len(d.data)) {
+			return nil, fmt.Errorf("%w: map too long", ErrInvalidCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, fmt.Errorf("%w: unsupported map key %T", ErrInvalidCBOR, k)
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	case 6:
+		return d.item(depth + 1)
+	default:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, arg)
+	}
+}
+
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+	b := d.data[d.pos]
+	d.pos++
+
+	major, info := b>>5, b&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if d.pos+size > len(d.data) {
+			return 0, 0, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+		buf := make([]byte, 8)
+		copy(buf[8-size:], d.data[d.pos:d.pos+size])
+		d.pos += size
+		if major == 7 && info > 24 {
+			return 0, 0, fmt.Errorf("%w: floats are not supported", ErrInvalidCBOR)
+		}
+		return major, binary.BigEndian.Uint64(buf), nil
+	}
+	return 0, 0, fmt.Errorf("%w: indefinite length items are not supported", ErrInvalidCBOR)
+}
+
+func (d *cborDecoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053)
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// COSE key parameters
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	coseKtyOKP     = 1
+	coseKtyEC2     = 2
+	coseKtyRSA     = 3
+	coseCrvP256    = 1
+	coseCrvEd25519 = 6
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported public key algorithm")
+	ErrInvalidSignature     = errors.New("invalid signature")
+)
+
+// publicKey is a parsed COSE_Key
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parsePublicKey(raw []byte) (*publicKey, error) {
+	v, n, err := decodeCBOR(raw)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(raw) {
+		return nil, fmt.Errorf("%w: trailing data after public key", ErrInvalidCBOR)
+	}
+	m, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: public key is not a map", ErrInvalidCBOR)
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == coseKtyEC2 && alg == AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid EC2 key", ErrUnsupportedAlgorithm)
+		}
+		// ecdh validates that the point is on the curve
+		point := make([]byte, 0, 65)
+		point = append(append(append(point, 0x04), x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedAlgorithm, err)
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		return &publicKey{alg: alg, key: pub}, nil
+	case kty == coseKtyOKP && alg == AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if crv != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid OKP key", ErrUnsupportedAlgorithm)
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKtyRSA && alg == AlgRS256:
+		n, _ := m[int64(coseN)].([]byte)
+		e, _ := m[int64(coseE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("%w: invalid RSA key", ErrUnsupportedAlgorithm)
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return &publicKey{alg: alg, key: pub}, nil
+	}
+	return nil, fmt.Errorf("%w: kty %d alg %d", ErrUnsupportedAlgorithm, kty, alg)
+}
+
+func (k *publicKey) verify(data, sig []byte) error {
+	digest := sha256.Sum256(data)
+
+	switch pub := k.key.(type) {
+	case ed25519.PublicKey:
+		// signs the message itself, not a digest
+		if !ed25519.Verify(pub, data, sig) {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return ErrInvalidSignature
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ChallengeSize = 32
+	Timeout       = 5 * time.Minute
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+var (
+	ErrInvalidClientData      = errors.New("invalid client data")
+	ErrInvalidAuthData        = errors.New("invalid authenticator data")
+	ErrUnsupportedAttestation = errors.New("unsupported attestation format")
+	// signature counter did not grow, the authenticator may be cloned
+	ErrSignCountRegression = errors.New("signature counter regression")
+)
+
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	// require user verification (PIN, biometrics) instead of presence only
+	RequireUserVerification bool
+}
+
+// Bytes is encoded as base64url in JSON, as WebAuthn JSON serialization does
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions is PublicKeyCredentialCreationOptionsJSON
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	Attestation            string                 `json:"attestation"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+}
+
+// RequestOptions is PublicKeyCredentialRequestOptionsJSON
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func (c *Config) userVerification() string {
+	if c.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+func (c *Config) CreationOptions(challenge []byte, user UserEntity, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:      user,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgEdDSA},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:                Timeout.Milliseconds(),
+		Attestation:            "none",
+		ExcludeCredentials:     descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "preferred", UserVerification: c.userVerification()},
+	}
+}
+
+func (c *Config) RequestOptions(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             c.RPID,
+		Timeout:          Timeout.Milliseconds(),
+		AllowCredentials: descriptors(allow),
+		UserVerification: c.userVerification(),
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	res := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		res[i] = CredentialDescriptor{Type: "public-key", ID: id}
+	}
+	return res
+}
+
+type ClientData struct {
+	Type      string `json:"type"`
+	Challenge Bytes  `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// ParseClientData decodes clientDataJSON, the challenge is needed to find the ceremony
+func ParseClientData(raw []byte) (*ClientData, error) {
+	cd := &ClientData{}
+	if err := json.Unmarshal(raw, cd); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidClientData, err)
+	}
+	return cd, nil
+}
+
+func (c *Config) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	cd, err := ParseClientData(raw)
+	if err != nil {
+		return err
+	}
+	if cd.Type != typ {
+		return fmt.Errorf("%w: unexpected type %q", ErrInvalidClientData, cd.Type)
+	}
+	if subtle.ConstantTimeCompare(cd.Challenge, challenge) != 1 {
+		return fmt.Errorf("%w: challenge mismatch", ErrInvalidClientData)
+	}
+	if !slices.Contains(c.Origins, cd.Origin) {
+		return fmt.Errorf("%w: unexpected origin %q", ErrInvalidClientData, cd.Origin)
+	}
+	return nil
+}
+
+type authData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthData(raw []byte) (*authData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidAuthData)
+	}
+	ad := &authData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rest := raw[37:]
+	if ad.flags&flagAttested != 0 {
+		// aaguid (16) + credential id length (2)
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: attested data too short", ErrInvalidAuthData)
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, fmt.Errorf("%w: credential id too short", ErrInvalidAuthData)
+		}
+		ad.credentialID, rest = rest[:idLen], rest[idLen:]
+
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAuthData, err)
+		}
+		ad.publicKey, rest = rest[:n], rest[n:]
+	}
+	if ad.flags&flagExtensions != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAuthData, err)
+		}
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: trailing data", ErrInvalidAuthData)
+	}
+	return ad, nil
+}
+
+func (c *Config) verifyAuthData(ad *authData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: relying party id mismatch", ErrInvalidAuthData)
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: user is not present", ErrInvalidAuthData)
+	}
+	if c.RequireUserVerification && ad.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: user is not verified", ErrInvalidAuthData)
+	}
+	return nil
+}
+
+// Credential is a verified new public key credential
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	Algorithm int
+	SignCount uint32
+}
+
+// VerifyRegistration validates the attestation response of navigator.credentials.create()
+func (c *Config) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	v, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	att, ok := v.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrInvalidCBOR)
+	}
+	if format, _ := att["fmt"].(string); format != "none" {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAttestation, format)
+	}
+	rawAuthData, _ := att["authData"].([]byte)
+
+	ad, err := parseAuthData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthData(ad); err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrInvalidAuthData)
+	}
+
+	pub, err := parsePublicKey(ad.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{
+		ID:        ad.credentialID,
+		PublicKey: ad.publicKey,
+		Algorithm: int(pub.alg),
+		SignCount: ad.signCount,
+	}, nil
+}
+
+// Assertion is a verified response of navigator.credentials.get()
+type Assertion struct {
+	SignCount uint32
+	// the authenticator checked a PIN or biometrics, not only presence
+	UserVerified bool
+}
+
+// VerifyAssertion validates the response of navigator.credentials.get() against
+// the stored credential and returns the new signature counter.
+func (c *Config) VerifyAssertion(challenge, publicKey []byte, signCount uint32, clientDataJSON, authenticatorData, signature []byte) (*Assertion, error) {
+	if err := c.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+
+	ad, err := parseAuthData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthData(ad); err != nil {
+		return nil, err
+	}
+
+	pub, err := parsePublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(slices.Clone(authenticatorData), clientDataHash[:]...)
+	if err := pub.verify(signed, signature); err != nil {
+		return nil, err
+	}
+
+	// authenticators without counter always report zero
+	if (ad.signCount != 0 || signCount != 0) && ad.signCount <= signCount {
+		return nil, ErrSignCountRegression
+	}
+
+	return &Assertion{SignCount: ad.signCount, UserVerified: ad.flags&flagUserVerified != 0}, nil
+}
@@ -0,0 +1,506 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+const (
+	testRPID   = "blog.example.com"
+	testOrigin = "https://blog.example.com"
+)
+
+func testConfig() *Config {
+	return &Config{RPID: testRPID, RPName: "Blog", Origins: []string{testOrigin}}
+}
+
+// cborMap keeps the order of keys, as authenticators encode them canonically
+type cborMap [][2]any
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= 0xff:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborEncode(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		b := cborHead(5, uint64(len(v)))
+		for _, kv := range v {
+			b = append(b, cborEncode(kv[0])...)
+			b = append(b, cborEncode(kv[1])...)
+		}
+		return b
+	}
+	panic("unsupported cbor value")
+}
+
+// testAuthenticator signs like a platform authenticator with one credential
+type testAuthenticator struct {
+	alg          int
+	signer       crypto.Signer
+	credentialID []byte
+	cosePub      []byte
+}
+
+func newTestAuthenticator(t *testing.T, alg int) *testAuthenticator {
+	t.Helper()
+	a := &testAuthenticator{alg: alg, credentialID: []byte("credential-" + big.NewInt(int64(-alg)).String())}
+
+	switch alg {
+	case AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		a.signer = key
+		a.cosePub = cborEncode(cborMap{
+			{coseKty, coseKtyEC2}, {coseAlg, AlgES256}, {coseCrv, coseCrvP256}, {coseX, x}, {coseY, y},
+		})
+	case AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.signer = key
+		a.cosePub = cborEncode(cborMap{
+			{coseKty, coseKtyRSA}, {coseAlg, AlgRS256}, {coseN, key.N.Bytes()}, {coseE, big.NewInt(int64(key.E)).Bytes()},
+		})
+	case AlgEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.signer = key
+		a.cosePub = cborEncode(cborMap{
+			{coseKty, coseKtyOKP}, {coseAlg, AlgEdDSA}, {coseCrv, coseCrvEd25519}, {coseX, []byte(pub)},
+		})
+	default:
+		t.Fatalf("unsupported algorithm %d", alg)
+	}
+	return a
+}
+
+func clientDataJSON(t *testing.T, typ string, challenge []byte, origin string) []byte {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func (a *testAuthenticator) authData(rpID string, flags byte, signCount uint32, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	b := append(rpIDHash[:], flags)
+	b = binary.BigEndian.AppendUint32(b, signCount)
+	if attested {
+		b = append(b, make([]byte, 16)...) // aaguid of attestation "none"
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.credentialID)))
+		b = append(b, a.credentialID...)
+		b = append(b, a.cosePub...)
+	}
+	return b
+}
+
+func (a *testAuthenticator) attestationObject(authData []byte) []byte {
+	return cborEncode(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}})
+}
+
+func (a *testAuthenticator) sign(t *testing.T, authData, clientData []byte) []byte {
+	t.Helper()
+	clientDataHash := sha256.Sum256(clientData)
+	signed := append(append([]byte{}, authData...), clientDataHash[:]...)
+
+	var (
+		sig []byte
+		err error
+	)
+	if a.alg == AlgEdDSA {
+		sig, err = a.signer.Sign(rand.Reader, signed, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(signed)
+		sig, err = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+var testAlgorithms = []struct {
+	name string
+	alg  int
+}{
+	{"ES256", AlgES256},
+	{"RS256", AlgRS256},
+	{"EdDSA", AlgEdDSA},
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	c := testConfig()
+	challenge := []byte("registration-challenge-32-bytes!")
+
+	for _, alg := range testAlgorithms {
+		t.Run(alg.name, func(t *testing.T) {
+			a := newTestAuthenticator(t, alg.alg)
+			cd := clientDataJSON(t, "webauthn.create", challenge, testOrigin)
+			att := a.attestationObject(a.authData(testRPID, flagUserPresent|flagUserVerified|flagAttested, 0, true))
+
+			cred, err := c.VerifyRegistration(challenge, cd, att)
+			if err != nil {
+				t.Fatalf("VerifyRegistration: %v", err)
+			}
+			if string(cred.ID) != string(a.credentialID) {
+				t.Errorf("credential id = %q, want %q", cred.ID, a.credentialID)
+			}
+			if string(cred.PublicKey) != string(a.cosePub) {
+				t.Error("public key is not the COSE key of the authenticator")
+			}
+			if cred.Algorithm != alg.alg {
+				t.Errorf("algorithm = %d, want %d", cred.Algorithm, alg.alg)
+			}
+		})
+	}
+}
+
+func TestVerifyRegistrationRejects(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	challenge := []byte("registration-challenge-32-bytes!")
+	flags := byte(flagUserPresent | flagAttested)
+	goodClientData := clientDataJSON(t, "webauthn.create", challenge, testOrigin)
+
+	tests := []struct {
+		name       string
+		config     *Config
+		clientData []byte
+		att        []byte
+		want       error
+	}{
+		{
+			name:       "relying party id hash",
+			clientData: goodClientData,
+			att:        a.attestationObject(a.authData("evil.example.com", flags, 0, true)),
+			want:       ErrInvalidAuthData,
+		},
+		{
+			name:       "origin",
+			clientData: clientDataJSON(t, "webauthn.create", challenge, "https://evil.example.com"),
+			att:        a.attestationObject(a.authData(testRPID, flags, 0, true)),
+			want:       ErrInvalidClientData,
+		},
+		{
+			name:       "ceremony type",
+			clientData: clientDataJSON(t, "webauthn.get", challenge, testOrigin),
+			att:        a.attestationObject(a.authData(testRPID, flags, 0, true)),
+			want:       ErrInvalidClientData,
+		},
+		{
+			name:       "challenge",
+			clientData: clientDataJSON(t, "webauthn.create", []byte("another-challenge"), testOrigin),
+			att:        a.attestationObject(a.authData(testRPID, flags, 0, true)),
+			want:       ErrInvalidClientData,
+		},
+		{
+			name:       "user not present",
+			clientData: goodClientData,
+			att:        a.attestationObject(a.authData(testRPID, flagAttested, 0, true)),
+			want:       ErrInvalidAuthData,
+		},
+		{
+			name:       "user not verified",
+			config:     &Config{RPID: testRPID, Origins: []string{testOrigin}, RequireUserVerification: true},
+			clientData: goodClientData,
+			att:        a.attestationObject(a.authData(testRPID, flags, 0, true)),
+			want:       ErrInvalidAuthData,
+		},
+		{
+			name:       "no attested credential",
+			clientData: goodClientData,
+			att:        a.attestationObject(a.authData(testRPID, flagUserPresent, 0, false)),
+			want:       ErrInvalidAuthData,
+		},
+		{
+			name:       "attestation format",
+			clientData: goodClientData,
+			att: cborEncode(cborMap{
+				{"fmt", "packed"}, {"attStmt", cborMap{}}, {"authData", a.authData(testRPID, flags, 0, true)},
+			}),
+			want: ErrUnsupportedAttestation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.config
+			if c == nil {
+				c = testConfig()
+			}
+			_, err := c.VerifyRegistration(challenge, tt.clientData, tt.att)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyRegistration error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	c := testConfig()
+	challenge := []byte("login-challenge-of-32-bytes-long")
+
+	for _, alg := range testAlgorithms {
+		t.Run(alg.name, func(t *testing.T) {
+			a := newTestAuthenticator(t, alg.alg)
+			cd := clientDataJSON(t, "webauthn.get", challenge, testOrigin)
+
+			ad := a.authData(testRPID, flagUserPresent|flagUserVerified, 8, false)
+			assertion, err := c.VerifyAssertion(challenge, a.cosePub, 7, cd, ad, a.sign(t, ad, cd))
+			if err != nil {
+				t.Fatalf("VerifyAssertion: %v", err)
+			}
+			if assertion.SignCount != 8 || !assertion.UserVerified {
+				t.Errorf("assertion = %+v, want sign count 8 and user verified", assertion)
+			}
+
+			presentOnly := a.authData(testRPID, flagUserPresent, 9, false)
+			assertion, err = c.VerifyAssertion(challenge, a.cosePub, 8, cd, presentOnly, a.sign(t, presentOnly, cd))
+			if err != nil {
+				t.Fatalf("VerifyAssertion without user verification: %v", err)
+			}
+			if assertion.UserVerified {
+				t.Error("user is reported verified without the UV flag")
+			}
+
+			// the signature covers the client data
+			other := clientDataJSON(t, "webauthn.get", challenge, testOrigin+"/")
+			if _, err := c.VerifyAssertion(challenge, a.cosePub, 7, cd, ad, a.sign(t, ad, other)); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("signature of other client data: error = %v, want ErrInvalidSignature", err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionRejects(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	other := newTestAuthenticator(t, AlgES256)
+	challenge := []byte("login-challenge-of-32-bytes-long")
+	cd := clientDataJSON(t, "webauthn.get", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent, 5, false)
+
+	tests := []struct {
+		name       string
+		publicKey  []byte
+		signCount  uint32
+		clientData []byte
+		authData   []byte
+		signature  []byte
+		want       error
+	}{
+		{
+			name:       "relying party id hash",
+			publicKey:  a.cosePub,
+			clientData: cd,
+			authData:   a.authData("evil.example.com", flagUserPresent, 5, false),
+			signature:  a.sign(t, a.authData("evil.example.com", flagUserPresent, 5, false), cd),
+			want:       ErrInvalidAuthData,
+		},
+		{
+			name:       "origin",
+			publicKey:  a.cosePub,
+			clientData: clientDataJSON(t, "webauthn.get", challenge, "https://blog.example.com.evil.net"),
+			authData:   ad,
+			signature:  a.sign(t, ad, clientDataJSON(t, "webauthn.get", challenge, "https://blog.example.com.evil.net")),
+			want:       ErrInvalidClientData,
+		},
+		{
+			name:       "signed by another key",
+			publicKey:  a.cosePub,
+			clientData: cd,
+			authData:   ad,
+			signature:  other.sign(t, ad, cd),
+			want:       ErrInvalidSignature,
+		},
+		{
+			name:       "same sign count",
+			publicKey:  a.cosePub,
+			signCount:  5,
+			clientData: cd,
+			authData:   ad,
+			signature:  a.sign(t, ad, cd),
+			want:       ErrSignCountRegression,
+		},
+		{
+			name:       "lower sign count",
+			publicKey:  a.cosePub,
+			signCount:  9,
+			clientData: cd,
+			authData:   ad,
+			signature:  a.sign(t, ad, cd),
+			want:       ErrSignCountRegression,
+		},
+		{
+			name:       "counter stopped after it was used",
+			publicKey:  a.cosePub,
+			signCount:  3,
+			clientData: cd,
+			authData:   a.authData(testRPID, flagUserPresent, 0, false),
+			signature:  a.sign(t, a.authData(testRPID, flagUserPresent, 0, false), cd),
+			want:       ErrSignCountRegression,
+		},
+	}
+
+	c := testConfig()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := c.VerifyAssertion(challenge, tt.publicKey, tt.signCount, tt.clientData, tt.authData, tt.signature)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("VerifyAssertion error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionWithoutCounter(t *testing.T) {
+	a := newTestAuthenticator(t, AlgEdDSA)
+	challenge := []byte("login-challenge-of-32-bytes-long")
+	cd := clientDataJSON(t, "webauthn.get", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent, 0, false)
+
+	// authenticators without a counter always report zero
+	assertion, err := testConfig().VerifyAssertion(challenge, a.cosePub, 0, cd, ad, a.sign(t, ad, cd))
+	if err != nil {
+		t.Fatalf("VerifyAssertion: %v", err)
+	}
+	if assertion.SignCount != 0 {
+		t.Errorf("sign count = %d, want 0", assertion.SignCount)
+	}
+}
+
+func TestMalformedInputDoesNotPanic(t *testing.T) {
+	a := newTestAuthenticator(t, AlgES256)
+	c := testConfig()
+	challenge := []byte("registration-challenge-32-bytes!")
+	cd := clientDataJSON(t, "webauthn.create", challenge, testOrigin)
+	ad := a.authData(testRPID, flagUserPresent|flagAttested, 0, true)
+	att := a.attestationObject(ad)
+
+	// every truncation of valid data is an error, never a panic or a success
+	for n := range len(att) {
+		if _, err := c.VerifyRegistration(challenge, cd, att[:n]); err == nil {
+			t.Errorf("attestation object cut to %d bytes is accepted", n)
+		}
+	}
+	for n := range len(ad) {
+		if _, err := c.VerifyRegistration(challenge, cd, a.attestationObject(ad[:n])); err == nil {
+			t.Errorf("authenticator data cut to %d bytes is accepted", n)
+		}
+	}
+	for n := range len(a.cosePub) {
+		if _, err := parsePublicKey(a.cosePub[:n]); err == nil {
+			t.Errorf("public key cut to %d bytes is accepted", n)
+		}
+	}
+
+	getCD := clientDataJSON(t, "webauthn.get", challenge, testOrigin)
+	assertionData := a.authData(testRPID, flagUserPresent, 1, false)
+	sig := a.sign(t, assertionData, getCD)
+	for n := range len(assertionData) {
+		if _, err := c.VerifyAssertion(challenge, a.cosePub, 0, getCD, assertionData[:n], sig); err == nil {
+			t.Errorf("assertion data cut to %d bytes is accepted", n)
+		}
+	}
+	if _, err := c.VerifyAssertion(challenge, a.cosePub, 0, getCD, assertionData, nil); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("empty signature: error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	deep := make([]byte, 0, cborMaxDepth+2)
+	for range cborMaxDepth + 2 {
+		deep = append(deep, 0x81) // array of one item
+	}
+	deep = append(deep, 0x00)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated length", []byte{0x19, 0x01}},
+		{"byte string longer than data", []byte{0x45, 0x01, 0x02}},
+		{"huge byte string", []byte{0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"huge map", []byte{0xbb, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"map without value", []byte{0xa1, 0x01}},
+		{"byte string map key", []byte{0xa1, 0x41, 0x00, 0x01}},
+		{"indefinite length", []byte{0x5f, 0x41, 0x00, 0xff}},
+		{"float", []byte{0xf9, 0x3c, 0x00}},
+		{"negative integer overflow", []byte{0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"too deep", deep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeCBOR(tt.data); !errors.Is(err, ErrInvalidCBOR) {
+				t.Errorf("decodeCBOR error = %v, want ErrInvalidCBOR", err)
+			}
+		})
+	}
+}
+
+func FuzzParseAuthData(f *testing.F) {
+	a := &testAuthenticator{
+		credentialID: []byte("credential"),
+		cosePub:      cborEncode(cborMap{{coseKty, coseKtyOKP}, {coseAlg, AlgEdDSA}, {coseCrv, coseCrvEd25519}, {coseX, make([]byte, 32)}}),
+	}
+	f.Add(a.authData(testRPID, flagUserPresent, 1, false))
+	f.Add(a.authData(testRPID, flagUserPresent|flagAttested, 0, true))
+	f.Add(a.authData(testRPID, flagUserPresent|flagAttested|flagExtensions, 0, true))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		ad, err := parseAuthData(data)
+		if err != nil {
+			return
+		}
+		if ad.publicKey != nil {
+			_, _ = parsePublicKey(ad.publicKey)
+		}
+	})
+}
@@ -1,5 +1,8 @@
 CREATE EXTENSION IF NOT EXISTS citext;
 
+-- schema version 0, later changes are migrations in internal/store/postgres/migrations
+-- which the api applies at startup
+
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     username VARCHAR(50) UNIQUE NOT NULL,
@@ -8,7 +11,7 @@ CREATE TABLE IF NOT EXISTS users (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE articles IF NOT EXISTS (
+CREATE TABLE IF NOT EXISTS articles (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) NOT NULL,
     content TEXT NOT NULL,
@@ -18,7 +21,7 @@ CREATE TABLE articles IF NOT EXISTS (
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE comments IF NOT EXISTS (
+CREATE TABLE IF NOT EXISTS comments (
     id SERIAL PRIMARY KEY,
     article_id INTEGER REFERENCES articles(id),
     user_id INTEGER REFERENCES users(id),
@@ -26,7 +29,7 @@ CREATE TABLE comments IF NOT EXISTS (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE TABLE article_like IF NOT EXISTS (
+CREATE TABLE IF NOT EXISTS article_like (
 	id SERIAL PRIMARY KEY,
 	article_id INTEGER REFERENCES articles(id) ON DELETE CASCADE,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,